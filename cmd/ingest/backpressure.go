@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"memory-machine/internal/config"
+)
+
+// depthCacheTTL is how long a sampled queue depth is reused, so busy periods
+// do not add a GetQueueAttributes call to every request.
+const depthCacheTTL = 10 * time.Second
+
+// queueAttributesAPI is the subset of the SQS client used to sample depth.
+type queueAttributesAPI interface {
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// depthCache remembers the last sampled queue depth for the container.
+type depthCache struct {
+	mu      sync.Mutex
+	depth   int
+	fetched time.Time
+}
+
+var queueDepth depthCache
+
+func (c *depthCache) get(ctx context.Context, client queueAttributesAPI, queueURL string, at time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetched.IsZero() && at.Sub(c.fetched) < depthCacheTTL {
+		return c.depth, nil
+	}
+	output, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueURL,
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameApproximateNumberOfMessages},
+	})
+	if err != nil {
+		return 0, err
+	}
+	depth, err := strconv.Atoi(output.Attributes[string(sqstypes.QueueAttributeNameApproximateNumberOfMessages)])
+	if err != nil {
+		return 0, err
+	}
+	c.depth, c.fetched = depth, at
+	return depth, nil
+}
+
+// underBackpressure reports whether the queue is deep enough that clients
+// should be asked to slow down. Sampling failures never throttle.
+func underBackpressure(ctx context.Context, settings config.Settings, client queueAttributesAPI) bool {
+	if settings.BackpressureDepth == 0 {
+		return false
+	}
+	depth, err := queueDepth.get(ctx, client, settings.SQSQueueURL, now())
+	if err != nil {
+		slog.Warn("queue depth sample failed", "error", err)
+		return false
+	}
+	return depth >= settings.BackpressureDepth
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/metrics"
+	"memory-machine/internal/models"
+)
+
+// batchRow is one record of a multi-record body: the parsed message, or why it
+// could not be parsed. line is 1-based.
+type batchRow struct {
+	line    int
+	message models.InternalMessage
+	err     error
+}
+
+// handleBatch enqueues every valid row produced by next. Invalid rows are
+// reported with their line numbers instead of failing the batch; a batch with
+// any failed row is answered with 207 Multi-Status. Rows are counted as they
+// are read, so an oversized body is rejected before anything is sent.
+func handleBatch(ctx context.Context, req events.APIGatewayV2HTTPRequest, settings config.Settings, contentType, priority string, next func() (batchRow, bool)) events.APIGatewayV2HTTPResponse {
+	result := models.BatchResponse{Status: "enqueued", Failed: []models.LineError{}}
+	var messages []models.InternalMessage
+	var inputs []*sqs.SendMessageInput
+	var lines []int
+	records := 0
+	for row, ok := next(); ok; row, ok = next() {
+		records++
+		if records > settings.MaxBatchRecords {
+			metrics.Count("AmplificationRejected", 1, nil)
+			return errorResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d records per request", settings.MaxBatchRecords))
+		}
+
+		message, err := row.message, row.err
+		var input *sqs.SendMessageInput
+		if err == nil {
+			_, err = checkTenantPolicy(settings, message, contentType)
+		}
+		if err == nil {
+			message.TraceID = uuid.NewString()
+			message.AmznTraceID = req.Headers["x-amzn-trace-id"]
+			message.Priority = priority
+			addProvenance(ctx, settings, &message)
+			input, err = queueInput(ctx, settings, message)
+		}
+		if err == nil {
+			if sizeErr := checkMessageSize(settings, input); sizeErr != nil {
+				err = sizeErr
+				if settings.PayloadBucket != "" {
+					err = offloadText(ctx, newS3Client(settings), settings.PayloadBucket, &message)
+					if err == nil {
+						input, err = queueInput(ctx, settings, message)
+					}
+				}
+			}
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, models.LineError{Line: row.line, Error: err.Error()})
+			continue
+		}
+		messages = append(messages, message)
+		inputs = append(inputs, input)
+		lines = append(lines, row.line)
+	}
+
+	for i, err := range enqueueBatch(ctx, settings, sqs.NewFromConfig(settings.AWSConfig), messages, inputs) {
+		if err != nil {
+			result.Failed = append(result.Failed, models.LineError{Line: lines[i], Error: err.Error()})
+			continue
+		}
+		result.Enqueued++
+	}
+	sort.Slice(result.Failed, func(a, b int) bool { return result.Failed[a].Line < result.Failed[b].Line })
+
+	if len(result.Failed) == 0 {
+		return jsonResponse(http.StatusAccepted, acceptedBody(req, settings, result))
+	}
+	result.Status = "partial"
+	if result.Enqueued == 0 {
+		result.Status = "failed"
+	}
+	return jsonResponse(http.StatusMultiStatus, acceptedBody(req, settings, result))
+}
+
+// maxBatchEntries is the SQS limit on entries per SendMessageBatch call.
+const maxBatchEntries = 10
+
+// enqueueBatch sends inputs, built by queueInput from the matching messages,
+// in SendMessageBatch calls of up to maxBatchEntries. The result holds one
+// error per message, in input order, nil for each message SQS accepted.
+func enqueueBatch(ctx context.Context, settings config.Settings, client *sqs.Client, messages []models.InternalMessage, inputs []*sqs.SendMessageInput) []error {
+	errs := make([]error, len(messages))
+	for start := 0; start < len(messages); start += maxBatchEntries {
+		end := min(start+maxBatchEntries, len(messages))
+
+		// Entry IDs are the message's index, so failures map straight back.
+		var entries []sqstypes.SendMessageBatchRequestEntry
+		for i := start; i < end; i++ {
+			input := inputs[i]
+			entries = append(entries, sqstypes.SendMessageBatchRequestEntry{
+				Id:                      stringPtr(strconv.Itoa(i)),
+				MessageBody:             input.MessageBody,
+				DelaySeconds:            input.DelaySeconds,
+				MessageGroupId:          input.MessageGroupId,
+				MessageDeduplicationId:  input.MessageDeduplicationId,
+				MessageAttributes:       input.MessageAttributes,
+				MessageSystemAttributes: input.MessageSystemAttributes,
+			})
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if err := checkEntryIDs(entries); err != nil {
+			slog.Error("refusing to send batch", "messages", len(entries), "error", err)
+			for _, entry := range entries {
+				index, _ := strconv.Atoi(*entry.Id)
+				errs[index] = err
+			}
+			continue
+		}
+
+		output, err := client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: &settings.SQSQueueURL, Entries: entries})
+		if err != nil {
+			slog.Error("failed to enqueue batch", "messages", len(entries), "error", err)
+			for _, entry := range entries {
+				index, _ := strconv.Atoi(*entry.Id)
+				errs[index] = errSendFailed
+			}
+			continue
+		}
+		for _, failed := range output.Failed {
+			index, convErr := strconv.Atoi(aws.ToString(failed.Id))
+			if convErr != nil || index < start || index >= end {
+				continue
+			}
+			logging.Message(messages[index]).Error("failed to enqueue message", "code", aws.ToString(failed.Code), "error", aws.ToString(failed.Message))
+			errs[index] = errSendFailed
+		}
+	}
+	return errs
+}
+
+// checkEntryIDs guards against a batch reusing an entry ID, which SQS rejects
+// whole. IDs are message indexes, so a duplicate means a bug in enqueueBatch.
+func checkEntryIDs(entries []sqstypes.SendMessageBatchRequestEntry) error {
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id := aws.ToString(entry.Id)
+		if seen[id] {
+			return fmt.Errorf("duplicate batch entry id %q", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
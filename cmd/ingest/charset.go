@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// decodeCharset transcodes body to UTF-8 from the charset parameter of a
+// Content-Type header, assuming UTF-8 when there is none. Charset names are
+// resolved like a browser would, so ISO-8859-1 decodes as windows-1252.
+func decodeCharset(contentType, body string) (string, error) {
+	name := charsetParam(contentType)
+	if name == "" {
+		return body, nil
+	}
+	encoding, err := htmlindex.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("unsupported charset %q", name)
+	}
+	if encoding == unicode.UTF8 {
+		return body, nil
+	}
+	decoded, err := encoding.NewDecoder().String(body)
+	if err != nil {
+		return "", fmt.Errorf("body is not valid %s", name)
+	}
+	return decoded, nil
+}
+
+// charsetParam returns the charset parameter of a Content-Type header, or ""
+// when there is none or the header does not parse. Unparseable types are
+// rejected by the content type switch instead.
+func charsetParam(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["charset"])
+}
+
+// unmarshalXML decodes an XML body that decodeCharset has already seen. A
+// charset on the Content-Type header wins over the XML declaration, so when
+// one was given the body is UTF-8 already and a declaration such as
+// encoding="ISO-8859-1" must not transcode it again. Without one, the
+// declared encoding is applied here.
+func unmarshalXML(contentType, body string, v any) error {
+	transcoded := charsetParam(contentType) != ""
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported charset %q", label)
+		}
+		if transcoded || encoding == unicode.UTF8 {
+			return input, nil
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	}
+	return decoder.Decode(v)
+}
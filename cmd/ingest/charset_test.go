@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCharsetTranscoding(t *testing.T) {
+	// "caf\xe9" is "café" in ISO-8859-1.
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"utf-8 default", "application/json", `{"tenant_id":"acme","text":"café"}`, http.StatusAccepted},
+		{"utf-8 charset", "application/json; charset=UTF-8", `{"tenant_id":"acme","text":"café"}`, http.StatusAccepted},
+		{"latin-1 json", "application/json; charset=ISO-8859-1", "{\"tenant_id\":\"acme\",\"text\":\"caf\xe9\"}", http.StatusAccepted},
+		{"latin-1 plain text", "text/plain; charset=iso-8859-1", "caf\xe9", http.StatusAccepted},
+		{"quoted charset", `application/json; charset="windows-1252"`, "{\"tenant_id\":\"acme\",\"text\":\"caf\xe9\"}", http.StatusAccepted},
+		{"unsupported charset", "application/json; charset=x-klingon", `{"tenant_id":"acme","text":"hello"}`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			req := ingestRequest(tt.contentType, tt.body)
+			req.Headers["x-tenant-id"] = "acme"
+
+			expectStatus(t, serve(t, req), tt.wantStatus)
+			if tt.wantStatus == http.StatusAccepted {
+				if got := fake.sentMessages(t)[0]["text"]; got != "café" {
+					t.Errorf("enqueued text = %q, want café", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCharsetXMLDeclaration(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"header and declaration", "application/xml; charset=ISO-8859-1", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><log><tenant_id>acme</tenant_id><text>caf\xe9</text></log>"},
+		{"declaration only", "application/xml", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><log><tenant_id>acme</tenant_id><text>caf\xe9</text></log>"},
+		{"header wins over declaration", "text/xml; charset=UTF-8", `<?xml version="1.0" encoding="ISO-8859-1"?><log><tenant_id>acme</tenant_id><text>café</text></log>`},
+		{"utf-8 declaration", "application/xml", `<?xml version="1.0" encoding="UTF-8"?><log><tenant_id>acme</tenant_id><text>café</text></log>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			expectStatus(t, serve(t, ingestRequest(tt.contentType, tt.body)), http.StatusAccepted)
+			if got := fake.sentMessages(t)[0]["text"]; got != "café" {
+				t.Errorf("enqueued text = %q, want café", got)
+			}
+		})
+	}
+}
+
+func TestCharsetXMLUnknownDeclaration(t *testing.T) {
+	newFakeAWS(t)
+	body := `<?xml version="1.0" encoding="x-klingon"?><log><tenant_id>acme</tenant_id><text>hello</text></log>`
+	expectStatus(t, serve(t, ingestRequest("application/xml", body)), http.StatusBadRequest)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/models"
+)
+
+const csvContentType = "text/csv"
+
+// handleCSV enqueues one message per data row. The header row names the
+// tenant_id, log_id, and text columns in any order; log_id is optional, and
+// tenant_id is too when an API key supplies the tenant. Rows are read one at
+// a time rather than parsing the whole file up front.
+func handleCSV(ctx context.Context, req events.APIGatewayV2HTTPRequest, settings config.Settings, body, priority, authTenant string) events.APIGatewayV2HTTPResponse {
+	reader := csv.NewReader(strings.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid CSV header")
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	required := []string{"text"}
+	if authTenant == "" {
+		required = append(required, "tenant_id")
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return errorResponse(http.StatusBadRequest, "missing required CSV column: "+name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	return handleBatch(ctx, req, settings, csvContentType, priority, func() (batchRow, bool) {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return batchRow{}, false
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			line := 0
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+			}
+			return batchRow{line: line, err: errors.New("invalid CSV row")}, true
+		}
+		line, _ := reader.FieldPos(0)
+
+		tenantID := field(record, "tenant_id")
+		if authTenant != "" {
+			tenantID = authTenant
+		}
+		text := field(record, "text")
+		if tenantID == "" || text == "" {
+			return batchRow{line: line, err: errors.New("tenant_id and text are required")}, true
+		}
+		logID := field(record, "log_id")
+		if logID == "" {
+			logID = uuid.NewString()
+		}
+		return batchRow{line: line, message: models.NewInternalMessage(tenantID, logID, "csv_upload", text)}, true
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/models"
+)
+
+// isItemEnvelope reports whether a JSON body has a top-level items key.
+func isItemEnvelope(body string) bool {
+	var probe struct {
+		Items json.RawMessage `json:"items"`
+	}
+	return json.Unmarshal([]byte(body), &probe) == nil && probe.Items != nil
+}
+
+// handleEnvelope enqueues one message per envelope item, derived from the
+// item's declared format. Item numbers are reported in the line field.
+func handleEnvelope(ctx context.Context, req events.APIGatewayV2HTTPRequest, settings config.Settings, body, priority, authTenant string) events.APIGatewayV2HTTPResponse {
+	var envelope models.ItemEnvelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid item envelope")
+	}
+	if settings.StrictJSON {
+		unknown, _ := unknownFields([]byte(body), envelope)
+		if len(unknown) > 0 {
+			return errorResponse(http.StatusBadRequest, "unknown fields: "+strings.Join(unknown, ", "))
+		}
+	}
+	if authTenant != "" {
+		envelope.TenantID = authTenant
+	}
+	if envelope.TenantID == "" {
+		return errorResponse(http.StatusBadRequest, "tenant_id is required")
+	}
+
+	next := 0
+	return handleBatch(ctx, req, settings, "application/json", priority, func() (batchRow, bool) {
+		if next >= len(envelope.Items) {
+			return batchRow{}, false
+		}
+		item := envelope.Items[next]
+		next++
+		message, err := parseEnvelopeItem(settings, envelope.TenantID, item)
+		return batchRow{line: next, message: message, err: err}, true
+	})
+}
+
+// parseEnvelopeItem validates item against its format. A json item's data is
+// stored as compact JSON text so the worker redacts it like any other text.
+func parseEnvelopeItem(settings config.Settings, tenantID string, item models.EnvelopeItem) (models.InternalMessage, error) {
+	var text string
+	switch item.Format {
+	case models.ItemFormatText:
+		if item.Text == "" {
+			return models.InternalMessage{}, errors.New("text is required")
+		}
+		if settings.RejectBinaryText && looksBinary(item.Text) {
+			return models.InternalMessage{}, errors.New("binary content not allowed")
+		}
+		text = item.Text
+	case models.ItemFormatJSON:
+		var compact bytes.Buffer
+		if !bytes.HasPrefix(bytes.TrimSpace(item.Data), []byte("{")) || json.Compact(&compact, item.Data) != nil {
+			return models.InternalMessage{}, errors.New("data must be a JSON object")
+		}
+		text = compact.String()
+	default:
+		return models.InternalMessage{}, fmt.Errorf("invalid format %q: use text or json", item.Format)
+	}
+	logID := item.LogID
+	if logID == "" {
+		logID = uuid.NewString()
+	}
+	return models.NewInternalMessage(tenantID, logID, "envelope_upload", text), nil
+}
@@ -1,98 +1,556 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/google/uuid"
 
+	"memory-machine/internal/apikey"
 	"memory-machine/internal/config"
+	"memory-machine/internal/idempotency"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/metrics"
 	"memory-machine/internal/models"
+	"memory-machine/internal/queuecrypt"
+	"memory-machine/internal/redaction"
 )
 
+// now is the handler's clock; tests can replace it.
+var now = time.Now
+
+// newIdempotencyStore returns the Idempotency-Key store; tests can replace it.
+var newIdempotencyStore = func(settings config.Settings) idempotency.Store {
+	return idempotency.NewDynamoStore(dynamodb.NewFromConfig(settings.AWSConfig), settings.IdempotencyTableName, settings.IdempotencyTTL)
+}
+
 func main() {
+	slog.SetDefault(logging.New())
 	lambda.Start(handleRequest)
 }
 
 func handleRequest(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	// Flush on return, and also if the deadline passes first, since the
+	// runtime may freeze the container before a timed-out handler returns.
+	defer metrics.Flush()
+	stopFlush := context.AfterFunc(ctx, metrics.Flush)
+	defer stopFlush()
+
 	settings, err := config.Load(ctx)
 	if err != nil {
-		log.Printf("configuration error: %v", err)
+		slog.Error("configuration error", "error", err)
 		return errorResponse(http.StatusInternalServerError, "internal configuration error"), nil
 	}
 
+	if current := now(); settings.Maintenance != nil && settings.Maintenance.Contains(current) {
+		resp := errorResponse(http.StatusServiceUnavailable, "service under maintenance")
+		retryAfter := math.Ceil(settings.Maintenance.End.Sub(current).Seconds())
+		resp.Headers["retry-after"] = strconv.Itoa(int(retryAfter))
+		return resp, nil
+	}
+
+	// API Gateway joins repeated headers, so this counts distinct names.
+	if settings.MaxHeaderCount > 0 && len(req.Headers) > settings.MaxHeaderCount {
+		return errorResponse(http.StatusRequestHeaderFieldsTooLarge, fmt.Sprintf("request has more than %d headers", settings.MaxHeaderCount)), nil
+	}
+
+	authTenant, err := authenticate(ctx, keyStore(settings), req.Headers["x-api-key"])
+	if err != nil && !errors.Is(err, apikey.ErrUnknownKey) {
+		slog.Error("API key lookup failed", "error", err)
+		return errorResponse(http.StatusInternalServerError, "authentication unavailable"), nil
+	}
+	if err != nil {
+		resp := errorResponse(http.StatusUnauthorized, "missing or invalid API key")
+		resp.Headers["www-authenticate"] = `ApiKey realm="ingest", header="X-Api-Key"`
+		return resp, nil
+	}
+
+	if settings.RequireContentLength {
+		length, lengthErr := strconv.ParseInt(req.Headers["content-length"], 10, 64)
+		if lengthErr != nil || length <= 0 {
+			return errorResponse(http.StatusLengthRequired, "Content-Length header required"), nil
+		}
+	}
+
 	body := req.Body
 	if req.IsBase64Encoded {
-		decoded, decodeErr := base64.StdEncoding.DecodeString(req.Body)
+		decoded, decodeErr := decodeBase64Body(req.Body, settings.MaxDecodedBodyBytes)
+		if errors.Is(decodeErr, errBodyTooLarge) {
+			return errorResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("decoded body exceeds %d bytes", settings.MaxDecodedBodyBytes)), nil
+		}
 		if decodeErr != nil {
 			return errorResponse(http.StatusBadRequest, "invalid base64 body"), nil
 		}
-		body = string(decoded)
+		body = decoded
+	}
+	if strings.EqualFold(req.Headers["content-encoding"], "gzip") {
+		decompressed, gzipErr := gunzipBody(body, settings.MaxDecodedBodyBytes)
+		if errors.Is(gzipErr, errBodyTooLarge) {
+			return errorResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("decompressed body exceeds %d bytes", settings.MaxDecodedBodyBytes)), nil
+		}
+		if gzipErr != nil {
+			return errorResponse(http.StatusBadRequest, "invalid gzip body"), nil
+		}
+		body = decompressed
+	}
+
+	priority, err := parsePriority(req.Headers["x-priority"])
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	inline, err := parseProcessingMode(req)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if inline && settings.SyncMaxTextBytes == 0 {
+		return errorResponse(http.StatusBadRequest, "synchronous processing is not enabled"), nil
 	}
 
 	contentType := strings.ToLower(strings.TrimSpace(strings.Split(req.Headers["content-type"], ";")[0]))
+	body, err = decodeCharset(req.Headers["content-type"], body)
+	if err != nil {
+		return errorResponse(http.StatusUnsupportedMediaType, err.Error()), nil
+	}
+	if inline && (contentType == ndjsonContentType || contentType == csvContentType) {
+		return errorResponse(http.StatusBadRequest, syncBatchMessage), nil
+	}
 
 	var message models.InternalMessage
 	switch contentType {
 	case "application/json":
+		if settings.ItemEnvelopes && isItemEnvelope(body) {
+			if inline {
+				return errorResponse(http.StatusBadRequest, syncBatchMessage), nil
+			}
+			return handleEnvelope(ctx, req, settings, body, priority, authTenant), nil
+		}
 		var payload models.JSONIngestRequest
 		if err := json.Unmarshal([]byte(body), &payload); err != nil {
 			return errorResponse(http.StatusBadRequest, "invalid JSON payload"), nil
 		}
+		if settings.StrictJSON {
+			unknown, _ := unknownFields([]byte(body), payload)
+			if len(unknown) > 0 {
+				return errorResponse(http.StatusBadRequest, "unknown fields: "+strings.Join(unknown, ", ")), nil
+			}
+		}
+		if authTenant != "" {
+			payload.TenantID = authTenant
+		}
 		if payload.TenantID == "" || payload.Text == "" {
 			return errorResponse(http.StatusBadRequest, "tenant_id and text are required"), nil
 		}
-		logID := payload.LogID
-		if logID == "" {
-			logID = uuid.NewString()
-		}
-		message = models.NewInternalMessage(payload.TenantID, logID, "json_upload", payload.Text)
+		message = models.NewInternalMessage(payload.TenantID, logIDFor(req, payload.LogID), "json_upload", payload.Text)
 	case "text/plain":
 		tenant := req.Headers["x-tenant-id"]
+		if authTenant != "" {
+			tenant = authTenant
+		}
 		if tenant == "" {
 			return errorResponse(http.StatusBadRequest, "missing X-Tenant-ID header"), nil
 		}
-		message = models.NewInternalMessage(tenant, uuid.NewString(), "text_upload", body)
+		if settings.RejectBinaryText && looksBinary(body) {
+			return errorResponse(http.StatusBadRequest, "binary content not allowed"), nil
+		}
+		message = models.NewInternalMessage(tenant, logIDFor(req, ""), "text_upload", body)
+	case "application/x-www-form-urlencoded":
+		if len(body) > settings.MaxFormBytes {
+			return errorResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("form body exceeds %d bytes", settings.MaxFormBytes)), nil
+		}
+		form, formErr := url.ParseQuery(body)
+		if formErr != nil {
+			return errorResponse(http.StatusBadRequest, "invalid form payload"), nil
+		}
+		if authTenant != "" {
+			form.Set("tenant_id", authTenant)
+		}
+		if form.Get("tenant_id") == "" || form.Get("text") == "" {
+			return errorResponse(http.StatusBadRequest, "tenant_id and text are required"), nil
+		}
+		message = models.NewInternalMessage(form.Get("tenant_id"), logIDFor(req, form.Get("log_id")), "form_upload", form.Get("text"))
+	case "application/xml", "text/xml":
+		var payload models.XMLIngestRequest
+		if err := unmarshalXML(req.Headers["content-type"], body, &payload); err != nil {
+			return errorResponse(http.StatusBadRequest, "invalid XML payload"), nil
+		}
+		if authTenant != "" {
+			payload.TenantID = authTenant
+		}
+		if payload.TenantID == "" || payload.Text == "" {
+			return errorResponse(http.StatusBadRequest, "tenant_id and text are required"), nil
+		}
+		message = models.NewInternalMessage(payload.TenantID, logIDFor(req, payload.LogID), "xml_upload", payload.Text)
+	case ndjsonContentType:
+		return handleNDJSON(ctx, req, settings, body, priority, authTenant), nil
+	case csvContentType:
+		return handleCSV(ctx, req, settings, body, priority, authTenant), nil
 	default:
-		return errorResponse(http.StatusBadRequest, "unsupported Content-Type. Use application/json or text/plain."), nil
+		return errorResponse(http.StatusBadRequest, "unsupported Content-Type. Use application/json, text/plain, application/x-www-form-urlencoded, application/xml, application/x-ndjson, or text/csv."), nil
 	}
 
-	client := sqs.NewFromConfig(settings.AWSConfig)
-	messageBody, _ := json.Marshal(message)
-	_, err = client.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:    &settings.SQSQueueURL,
-		MessageBody: stringPtr(string(messageBody)),
-	})
+	if code, policyErr := checkTenantPolicy(settings, message, contentType); policyErr != nil {
+		return errorResponse(code, policyErr.Error()), nil
+	}
+
+	message.TraceID = uuid.NewString()
+	message.AmznTraceID = req.Headers["x-amzn-trace-id"]
+	message.Priority = priority
+
+	if inline {
+		if len(message.Text) > settings.SyncMaxTextBytes {
+			return errorResponse(http.StatusRequestEntityTooLarge, fmt.Sprintf("text exceeds %d bytes for synchronous processing; send it without sync", settings.SyncMaxTextBytes)), nil
+		}
+		addProvenance(ctx, settings, &message)
+		return processSync(ctx, req, settings, message), nil
+	}
+
+	addProvenance(ctx, settings, &message)
+	input, err := queueInput(ctx, settings, message)
 	if err != nil {
-		log.Printf("failed to enqueue message: %v", err)
-		return errorResponse(http.StatusInternalServerError, "failed to enqueue message"), nil
+		return errorResponse(http.StatusInternalServerError, err.Error()), nil
+	}
+	offload := false
+	if sizeErr := checkMessageSize(settings, input); sizeErr != nil {
+		if settings.PayloadBucket == "" {
+			return errorResponse(http.StatusRequestEntityTooLarge, sizeErr.Error()), nil
+		}
+		offload = true
 	}
 
-	resp := models.EnqueueResponse{
+	// The response does not depend on SQS, so it is rendered up front and stored
+	// with the idempotency claim; repeats then get a byte-identical body.
+	client := sqs.NewFromConfig(settings.AWSConfig)
+	throttled := underBackpressure(ctx, settings, client)
+	response := models.EnqueueResponse{
 		Status:   "enqueued",
 		TenantID: message.TenantID,
 		LogID:    message.LogID,
+		TraceID:  message.TraceID,
 	}
-	payload, _ := json.Marshal(resp)
+	if throttled {
+		response.Status = "throttled_accepted"
+	}
+	if req.QueryStringParameters["explain"] == "true" {
+		response.RedactionRules = redaction.Matching(message.Text, settings.RedactionRules)
+	}
+	respBody := acceptedBody(req, settings, response)
 
-	return events.APIGatewayV2HTTPResponse{
-		StatusCode: http.StatusAccepted,
-		Body:       string(payload),
-		Headers: map[string]string{
-			"content-type": "application/json",
+	var store idempotency.Store
+	idempotencyKey := req.Headers["idempotency-key"]
+	if idempotencyKey != "" && settings.IdempotencyTableName != "" {
+		store = newIdempotencyStore(settings)
+		existing, claimErr := store.Claim(ctx, idempotencyKey, idempotency.Record{TenantID: message.TenantID, LogID: message.LogID, Response: respBody})
+		if claimErr != nil {
+			logging.Message(message).Error("idempotency check failed", "error", claimErr)
+			return errorResponse(http.StatusInternalServerError, "idempotency check failed"), nil
+		}
+		if existing != nil && existing.Pending {
+			resp := errorResponse(http.StatusConflict, "a request with this Idempotency-Key is in progress")
+			resp.Headers["retry-after"] = "1"
+			return resp, nil
+		}
+		if existing != nil {
+			slog.Info("idempotent repeat", "tenant_id", existing.TenantID, "log_id", existing.LogID)
+			if len(existing.Response) == 0 {
+				// Claims made before responses were stored only have the IDs.
+				existing.Response = acceptedBody(req, settings, models.EnqueueResponse{
+					Status:   "enqueued",
+					TenantID: existing.TenantID,
+					LogID:    existing.LogID,
+				})
+			}
+			return jsonResponse(http.StatusAccepted, existing.Response), nil
+		}
+	}
+
+	if offload {
+		err = offloadText(ctx, newS3Client(settings), settings.PayloadBucket, &message)
+		if err == nil {
+			// The body now carries the reference instead of the text.
+			input, err = queueInput(ctx, settings, message)
+		}
+	}
+	if err == nil {
+		err = enqueue(ctx, client, message, input)
+	}
+	if err != nil {
+		if store != nil {
+			if releaseErr := store.Release(ctx, message.TenantID, idempotencyKey); releaseErr != nil {
+				logging.Message(message).Error("failed to release idempotency key", "error", releaseErr)
+			}
+		}
+		if errors.Is(err, errSendFailed) && settings.EnqueueRetryAfter > 0 {
+			return retryResponse(settings.EnqueueRetryAfter, idempotencyKey), nil
+		}
+		return errorResponse(http.StatusInternalServerError, err.Error()), nil
+	}
+
+	if store != nil {
+		// The message is queued either way; a claim left pending only lets a
+		// retry enqueue it again once stale, which the worker deduplicates.
+		if completeErr := store.Complete(ctx, message.TenantID, idempotencyKey); completeErr != nil {
+			logging.Message(message).Error("failed to complete idempotency key", "error", completeErr)
+		}
+	}
+
+	resp := jsonResponse(http.StatusAccepted, respBody)
+	if throttled {
+		resp.Headers["retry-after"] = strconv.Itoa(settings.BackpressureRetryAfter)
+	}
+	return resp, nil
+}
+
+// maxDeduplicationIDLength is the SQS limit on MessageDeduplicationId.
+const maxDeduplicationIDLength = 128
+
+// fifoDeduplicationID is tenant_id:log_id, so SQS drops a repeat of the same
+// log within its five-minute deduplication window. IDs too long for SQS or with
+// characters it rejects are replaced by their SHA-256.
+func fifoDeduplicationID(message models.InternalMessage) string {
+	id := message.TenantID + ":" + message.LogID
+	if len(id) <= maxDeduplicationIDLength && isDeduplicationSafe(id) {
+		return id
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// isDeduplicationSafe reports whether id uses only the ASCII letters, digits,
+// and punctuation SQS allows in a deduplication ID.
+func isDeduplicationSafe(id string) bool {
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// errSendFailed reports an SQS send that failed after the SDK's own retries,
+// which is usually transient.
+var errSendFailed = errors.New("failed to enqueue message")
+
+// retryResponse is a 503 carrying a retry_token for the client to send as its
+// Idempotency-Key, so the retry cannot be enqueued twice. A client-supplied key
+// is echoed back; otherwise a fresh one is minted.
+func retryResponse(retryAfter int, idempotencyKey string) events.APIGatewayV2HTTPResponse {
+	token := idempotencyKey
+	if token == "" {
+		token = uuid.NewString()
+	}
+	body, _ := json.Marshal(map[string]string{"error": errSendFailed.Error(), "retry_token": token})
+	resp := jsonResponse(http.StatusServiceUnavailable, body)
+	resp.Headers["retry-after"] = strconv.Itoa(retryAfter)
+	return resp
+}
+
+// keyStore returns the API key store for settings, or nil when ingest is
+// unauthenticated. Other backends plug in here; tests can replace it.
+var keyStore = func(settings config.Settings) apikey.KeyStore {
+	if len(settings.APIKeys) == 0 {
+		return nil
+	}
+	return apikey.StaticStore(settings.APIKeys)
+}
+
+// authenticate resolves the tenant for an X-Api-Key header. It returns an
+// empty tenant and no error when store is nil.
+func authenticate(ctx context.Context, store apikey.KeyStore, key string) (string, error) {
+	if store == nil {
+		return "", nil
+	}
+	return store.TenantFor(ctx, key)
+}
+
+// logIDFor picks a message's log_id: the Idempotency-Key header, then the
+// log_id from the body, then a fresh UUID. Using the key means client retries
+// land on the same item, which the worker's conditional write deduplicates.
+func logIDFor(req events.APIGatewayV2HTTPRequest, bodyLogID string) string {
+	if key := req.Headers["idempotency-key"]; key != "" {
+		return key
+	}
+	if bodyLogID != "" {
+		return bodyLogID
+	}
+	return uuid.NewString()
+}
+
+// parsePriority validates an X-Priority header, defaulting to normal.
+func parsePriority(header string) (string, error) {
+	switch priority := strings.ToLower(strings.TrimSpace(header)); priority {
+	case "":
+		return models.PriorityNormal, nil
+	case models.PriorityHigh, models.PriorityNormal, models.PriorityLow:
+		return priority, nil
+	default:
+		return "", fmt.Errorf("invalid X-Priority %q: use high, normal, or low", header)
+	}
+}
+
+// processingModeSync is the X-Processing-Mode value that skips the queue.
+const processingModeSync = "sync"
+
+// syncBatchMessage rejects multi-record bodies sent for synchronous processing.
+const syncBatchMessage = "synchronous processing supports single records only"
+
+// parseProcessingMode reports whether the request should be processed inline,
+// from an X-Processing-Mode header or, failing that, a ?sync=true query
+// parameter. The default is async.
+func parseProcessingMode(req events.APIGatewayV2HTTPRequest) (bool, error) {
+	header := req.Headers["x-processing-mode"]
+	switch mode := strings.ToLower(strings.TrimSpace(header)); mode {
+	case "":
+	case "async":
+		return false, nil
+	case processingModeSync:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid X-Processing-Mode %q: use sync or async", header)
+	}
+
+	switch query := req.QueryStringParameters["sync"]; query {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid sync parameter %q: use true or false", query)
+	}
+}
+
+// checkMessageSize rejects inputs larger than the configured maximum, measured
+// the way SQS does: the body as sent, so encrypted when encryption is on, plus
+// each message attribute's name, data type, and value.
+func checkMessageSize(settings config.Settings, input *sqs.SendMessageInput) error {
+	size := len(aws.ToString(input.MessageBody))
+	for name, attribute := range input.MessageAttributes {
+		size += len(name) + len(aws.ToString(attribute.DataType)) + len(aws.ToString(attribute.StringValue)) + len(attribute.BinaryValue)
+	}
+	if size > settings.MaxTextBytes {
+		return fmt.Errorf("message is %d bytes; the limit is %d bytes", size, settings.MaxTextBytes)
+	}
+	return nil
+}
+
+// checkTenantPolicy applies the per-tenant content type and source rules,
+// returning the status code to reject with when one is violated.
+func checkTenantPolicy(settings config.Settings, message models.InternalMessage, contentType string) (int, error) {
+	if settings.RegisteredTenants != nil && !settings.RegisteredTenants[message.TenantID] {
+		return http.StatusForbidden, errors.New("unknown tenant")
+	}
+
+	if allowed, ok := settings.TenantContentTypes[message.TenantID]; ok && !containsString(allowed, contentType) {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("content type %s not allowed for tenant", contentType)
+	}
+
+	if expected, ok := settings.TenantSources[message.TenantID]; ok && expected != message.Source {
+		if settings.TenantSourceStrict {
+			return http.StatusBadRequest, fmt.Errorf("source %s not allowed for tenant; expected %s", message.Source, expected)
+		}
+		logging.Message(message).Warn("unexpected source", "expected", expected)
+	}
+	return 0, nil
+}
+
+// addProvenance records where the message was ingested when enabled.
+func addProvenance(ctx context.Context, settings config.Settings, message *models.InternalMessage) {
+	if !settings.EnrichProvenance {
+		return
+	}
+	message.IngestRegion = settings.AWSConfig.Region
+	accountID, err := resolveAccountID(ctx, sts.NewFromConfig(settings.AWSConfig))
+	if err != nil {
+		logging.Message(*message).Warn("provenance lookup failed", "error", err)
+	}
+	message.AccountID = accountID
+}
+
+// enqueue sends input, built by queueInput from message. Failures are logged
+// here; the returned error's text is safe to show to clients.
+func enqueue(ctx context.Context, client *sqs.Client, message models.InternalMessage, input *sqs.SendMessageInput) error {
+	if _, err := client.SendMessage(ctx, input); err != nil {
+		logging.Message(message).Error("failed to enqueue message", "error", err)
+		return errSendFailed
+	}
+	return nil
+}
+
+// queueInput serializes, optionally encrypts, and builds the SendMessage input
+// for message, with the same error contract as enqueue.
+func queueInput(ctx context.Context, settings config.Settings, message models.InternalMessage) (*sqs.SendMessageInput, error) {
+	messageBody, err := message.Canonical()
+	if err != nil {
+		logging.Message(message).Error("failed to serialize message", "error", err)
+		return nil, errors.New("failed to serialize message")
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:    &settings.SQSQueueURL,
+		MessageBody: stringPtr(string(messageBody)),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			models.AttributeTenantID: stringAttribute(message.TenantID),
+			models.AttributeSource:   stringAttribute(message.Source),
 		},
-	}, nil
+	}
+	if message.Priority == models.PriorityLow {
+		input.DelaySeconds = settings.LowPriorityDelay
+	}
+	if settings.FIFOQueue {
+		input.MessageGroupId = stringPtr(message.TenantID)
+		input.MessageDeduplicationId = stringPtr(fifoDeduplicationID(message))
+	}
+	if message.AmznTraceID != "" {
+		input.MessageSystemAttributes = map[string]sqstypes.MessageSystemAttributeValue{
+			string(sqstypes.MessageSystemAttributeNameForSendsAWSTraceHeader): {DataType: stringPtr("String"), StringValue: stringPtr(message.AmznTraceID)},
+		}
+	}
+	if settings.EncryptQueueBody {
+		sealed, encryptErr := queuecrypt.Encrypt(ctx, kms.NewFromConfig(settings.AWSConfig), settings.KMSKeyFor(message.TenantID), messageBody)
+		if encryptErr != nil {
+			logging.Message(message).Error("failed to encrypt message", "error", encryptErr)
+			return nil, errors.New("failed to encrypt message")
+		}
+		input.MessageBody = stringPtr(string(sealed))
+		input.MessageAttributes[queuecrypt.AttributeName] = stringAttribute(queuecrypt.AttributeValue)
+	}
+	return input, nil
 }
 
-func errorResponse(code int, msg string) events.APIGatewayV2HTTPResponse {
-	body, _ := json.Marshal(map[string]string{"error": msg})
+// acceptedBody renders resp, wrapped in the versioned envelope when configured
+// or requested.
+func acceptedBody(req events.APIGatewayV2HTTPRequest, settings config.Settings, resp any) []byte {
+	if settings.ResponseEnvelope || acceptsVersion(req.Headers["accept"], models.ResponseVersion) {
+		payload, _ := json.Marshal(models.ResponseEnvelope{Version: models.ResponseVersion, Data: resp})
+		return payload
+	}
+	payload, _ := json.Marshal(resp)
+	return payload
+}
+
+func jsonResponse(code int, body []byte) events.APIGatewayV2HTTPResponse {
 	return events.APIGatewayV2HTTPResponse{
 		StatusCode: code,
 		Body:       string(body),
@@ -102,6 +560,92 @@ func errorResponse(code int, msg string) events.APIGatewayV2HTTPResponse {
 	}
 }
 
+func errorResponse(code int, msg string) events.APIGatewayV2HTTPResponse {
+	body, _ := json.Marshal(map[string]string{"error": msg})
+	return jsonResponse(code, body)
+}
+
+// acceptsVersion reports whether an Accept header requests the versioned
+// envelope, e.g. "application/json; version=1".
+func acceptsVersion(accept string, version int) bool {
+	for _, part := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && params["version"] == strconv.Itoa(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// errBodyTooLarge reports a decoded body over the configured limit.
+var errBodyTooLarge = errors.New("decoded body too large")
+
+// decodeBase64Body streams the decode through a limited reader so at most
+// limit+1 bytes are ever allocated.
+func decodeBase64Body(encoded string, limit int64) (string, error) {
+	return readLimited(base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded)), limit)
+}
+
+// gunzipBody decompresses a gzip body under the same bound as
+// decodeBase64Body, so a small bomb never inflates past limit+1 bytes.
+func gunzipBody(compressed string, limit int64) (string, error) {
+	reader, err := gzip.NewReader(strings.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	return readLimited(reader, limit)
+}
+
+// readLimited reads reader to the end, failing with errBodyTooLarge once more
+// than limit bytes have been read.
+func readLimited(reader io.Reader, limit int64) (string, error) {
+	decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(decoded)) > limit {
+		return "", errBodyTooLarge
+	}
+	return string(decoded), nil
+}
+
+// binaryThreshold is the fraction of non-printable bytes above which a body is treated as binary.
+const binaryThreshold = 0.3
+
+// looksBinary reports whether body is likely binary rather than text. A NUL byte
+// or invalid UTF-8 is decisive; otherwise the share of control characters is compared
+// against binaryThreshold.
+func looksBinary(body string) bool {
+	if body == "" {
+		return false
+	}
+	if strings.IndexByte(body, 0) >= 0 || !utf8.ValidString(body) {
+		return true
+	}
+	nonPrintable := 0
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if (c < 0x20 && c != '\t' && c != '\n' && c != '\r') || c == 0x7f {
+			nonPrintable++
+		}
+	}
+	return float64(nonPrintable)/float64(len(body)) > binaryThreshold
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func stringAttribute(value string) sqstypes.MessageAttributeValue {
+	return sqstypes.MessageAttributeValue{DataType: stringPtr("String"), StringValue: stringPtr(value)}
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
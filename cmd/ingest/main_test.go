@@ -0,0 +1,1794 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+
+	"memory-machine/internal/apikey"
+	"memory-machine/internal/config"
+	"memory-machine/internal/idempotency"
+	"memory-machine/internal/models"
+	"memory-machine/internal/queuecrypt"
+)
+
+// awsCall is one request received by fakeAWS: the X-Amz-Target operation,
+// e.g. "AmazonSQS.SendMessage", and its decoded JSON input.
+type awsCall struct {
+	target string
+	input  map[string]any
+}
+
+// fakeResponse is what fakeAWS answers for an operation.
+type fakeResponse struct {
+	status int
+	body   any
+}
+
+// fakeAWS stands in for the JSON-protocol AWS APIs behind AWS_ENDPOINT_URL.
+// It records every call and answers with the response set for its operation,
+// or an empty object.
+type fakeAWS struct {
+	mu        sync.Mutex
+	calls     []awsCall
+	responses map[string]fakeResponse
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	raw, _ := io.ReadAll(r.Body)
+	var input map[string]any
+	_ = json.Unmarshal(raw, &input)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, awsCall{target: target, input: input})
+	response, ok := f.responses[target]
+	f.mu.Unlock()
+	if !ok {
+		response = fakeResponse{status: http.StatusOK, body: map[string]any{}}
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(response.status)
+	_ = json.NewEncoder(w).Encode(response.body)
+}
+
+// respond sets the answer for target.
+func (f *fakeAWS) respond(target string, status int, body any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[target] = fakeResponse{status: status, body: body}
+}
+
+// fail answers target with an AWS error of the given type.
+func (f *fakeAWS) fail(target, errorType string) {
+	f.respond(target, http.StatusBadRequest, map[string]string{"__type": errorType, "message": "fake failure"})
+}
+
+// inputs returns the inputs of every call to target, in order.
+func (f *fakeAWS) inputs(target string) []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var inputs []map[string]any
+	for _, call := range f.calls {
+		if call.target == target {
+			inputs = append(inputs, call.input)
+		}
+	}
+	return inputs
+}
+
+// sentMessages returns the InternalMessage bodies passed to SendMessage.
+func (f *fakeAWS) sentMessages(t *testing.T) []map[string]any {
+	t.Helper()
+	var messages []map[string]any
+	for _, input := range f.inputs(sendMessage) {
+		var message map[string]any
+		if err := json.Unmarshal([]byte(input["MessageBody"].(string)), &message); err != nil {
+			t.Fatalf("SendMessage body is not JSON: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+const (
+	sendMessage      = "AmazonSQS.SendMessage"
+	sendMessageBatch = "AmazonSQS.SendMessageBatch"
+)
+
+// newFakeAWS points the AWS SDK and the required settings at a fresh fakeAWS.
+// Other settings are left unset, so each test enables only what it covers.
+func newFakeAWS(t *testing.T) *fakeAWS {
+	t.Helper()
+	fake := &fakeAWS{responses: map[string]fakeResponse{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("SQS_QUEUE_URL", server.URL+"/000000000000/ingest")
+	t.Setenv("DYNAMODB_TABLE_NAME", "tenant-logs")
+	return fake
+}
+
+// ingestRequest builds a request with the given Content-Type and body.
+func ingestRequest(contentType, body string) events.APIGatewayV2HTTPRequest {
+	return events.APIGatewayV2HTTPRequest{
+		Headers: map[string]string{"content-type": contentType},
+		Body:    body,
+	}
+}
+
+// serve runs handleRequest and fails the test on a handler error.
+func serve(t *testing.T, req events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+	t.Helper()
+	resp, err := handleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handleRequest: %v", err)
+	}
+	return resp
+}
+
+// decodeBody unmarshals a JSON response body.
+func decodeBody(t *testing.T, resp events.APIGatewayV2HTTPResponse) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatalf("response body %q is not JSON: %v", resp.Body, err)
+	}
+	return body
+}
+
+// expectStatus fails the test unless resp has the wanted status code.
+func expectStatus(t *testing.T, resp events.APIGatewayV2HTTPResponse, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("status = %d, want %d (body %s)", resp.StatusCode, want, resp.Body)
+	}
+}
+
+func TestLooksBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"empty", "", false},
+		{"plain text", "user logged in from 10.0.0.1", false},
+		{"whitespace controls", "line one\n\tline two\r\n", false},
+		{"multibyte text", "résumé 日本語", false},
+		{"nul byte", "PK\x03\x04\x00\x00", true},
+		{"invalid utf-8", "\xff\xfe\xfd", true},
+		{"mostly control bytes", "\x01\x02\x03\x04ab", true},
+		{"few control bytes", "a normal line with one \x07 bell", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksBinary(tt.body); got != tt.want {
+				t.Errorf("looksBinary(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectBinaryText(t *testing.T) {
+	tests := []struct {
+		name   string
+		reject string
+		body   string
+		want   int
+	}{
+		{"text accepted", "true", "hello world", http.StatusAccepted},
+		{"binary rejected", "true", "\x00\x01\x02binary", http.StatusBadRequest},
+		{"binary accepted when disabled", "", "\x00\x01\x02binary", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("REJECT_BINARY_TEXT", tt.reject)
+			req := ingestRequest("text/plain", tt.body)
+			req.Headers["x-tenant-id"] = "acme"
+
+			resp := serve(t, req)
+			expectStatus(t, resp, tt.want)
+			sent := len(fake.inputs(sendMessage))
+			if tt.want == http.StatusBadRequest {
+				if got := decodeBody(t, resp)["error"]; got != "binary content not allowed" {
+					t.Errorf("error = %v, want binary content not allowed", got)
+				}
+				if sent != 0 {
+					t.Errorf("sent %d messages for a rejected body", sent)
+				}
+			} else if sent != 1 {
+				t.Errorf("sent %d messages, want 1", sent)
+			}
+		})
+	}
+}
+
+// staticKMS decrypts every data key to key, as the fake KMS endpoint issues.
+type staticKMS struct {
+	key []byte
+}
+
+func (s staticKMS) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	return &kms.GenerateDataKeyOutput{Plaintext: s.key, CiphertextBlob: []byte("wrapped")}, nil
+}
+
+func (s staticKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: s.key}, nil
+}
+
+func TestEncryptQueueBody(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("ENCRYPT_QUEUE_BODY", "true")
+	t.Setenv("KMS_KEY_ID", "alias/ingest")
+	key := bytes.Repeat([]byte{7}, 32)
+	fake.respond("TrentService.GenerateDataKey", http.StatusOK, map[string]any{
+		"KeyId":          "alias/ingest",
+		"Plaintext":      key,
+		"CiphertextBlob": []byte("wrapped"),
+	})
+
+	resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"call 555-123-4567"}`))
+	expectStatus(t, resp, http.StatusAccepted)
+
+	keyRequests := fake.inputs("TrentService.GenerateDataKey")
+	if len(keyRequests) != 1 || keyRequests[0]["KeyId"] != "alias/ingest" {
+		t.Fatalf("GenerateDataKey calls = %v, want one for alias/ingest", keyRequests)
+	}
+	sends := fake.inputs(sendMessage)
+	if len(sends) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sends))
+	}
+	body := sends[0]["MessageBody"].(string)
+	if strings.Contains(body, "555-123-4567") {
+		t.Fatalf("queued body contains plaintext: %s", body)
+	}
+	attributes := sends[0]["MessageAttributes"].(map[string]any)
+	marker, _ := attributes[queuecrypt.AttributeName].(map[string]any)
+	if marker["StringValue"] != queuecrypt.AttributeValue {
+		t.Errorf("%s attribute = %v, want %s", queuecrypt.AttributeName, marker, queuecrypt.AttributeValue)
+	}
+
+	plaintext, err := queuecrypt.Decrypt(context.Background(), staticKMS{key: key}, []byte(body))
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	var message map[string]any
+	if err := json.Unmarshal(plaintext, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message["tenant_id"] != "acme" || message["text"] != "call 555-123-4567" {
+		t.Errorf("decrypted message = %v", message)
+	}
+}
+
+func TestMessageSizeCountsEncryptedBody(t *testing.T) {
+	// The plaintext message fits in MAX_TEXT_BYTES; its envelope, about a
+	// third larger, does not.
+	text := strings.Repeat("a", 3000)
+	for _, tt := range []struct {
+		encrypt    bool
+		wantStatus int
+	}{{false, http.StatusAccepted}, {true, http.StatusRequestEntityTooLarge}} {
+		t.Run(fmt.Sprintf("encrypt=%v", tt.encrypt), func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAX_TEXT_BYTES", "4000")
+			t.Setenv("ENCRYPT_QUEUE_BODY", strconv.FormatBool(tt.encrypt))
+			t.Setenv("KMS_KEY_ID", "alias/ingest")
+			fake.respond("TrentService.GenerateDataKey", http.StatusOK, map[string]any{
+				"Plaintext":      bytes.Repeat([]byte{7}, 32),
+				"CiphertextBlob": []byte("wrapped"),
+			})
+
+			expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"`+text+`"}`)), tt.wantStatus)
+			if sends := len(fake.inputs(sendMessage)); tt.wantStatus != http.StatusAccepted && sends != 0 {
+				t.Errorf("sent %d messages over the limit", sends)
+			}
+		})
+	}
+}
+
+func TestCheckMessageSizeCountsAttributes(t *testing.T) {
+	input := &sqs.SendMessageInput{
+		MessageBody: stringPtr("hello"),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			models.AttributeTenantID: stringAttribute("acme"),
+		},
+	}
+	// "hello" plus "tenant_id", "String" and "acme".
+	size := len("hello") + len(models.AttributeTenantID) + len("String") + len("acme")
+	if err := checkMessageSize(config.Settings{MaxTextBytes: size}, input); err != nil {
+		t.Errorf("input of exactly the limit rejected: %v", err)
+	}
+	if err := checkMessageSize(config.Settings{MaxTextBytes: size - 1}, input); err == nil {
+		t.Error("attributes not counted toward the limit")
+	}
+}
+
+// setNow fixes the handler clock at t for the rest of the test.
+func setNow(t *testing.T, at time.Time) {
+	t.Helper()
+	previous := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = previous })
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		at             time.Time
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"before", start.Add(-time.Second), http.StatusAccepted, ""},
+		{"at start", start, http.StatusServiceUnavailable, "3600"},
+		{"inside", start.Add(30*time.Minute + 500*time.Millisecond), http.StatusServiceUnavailable, "1800"},
+		{"at end", start.Add(time.Hour), http.StatusAccepted, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAINTENANCE_WINDOW", "2026-03-01T02:00:00Z/2026-03-01T03:00:00Z")
+			setNow(t, tt.at)
+
+			resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+			expectStatus(t, resp, tt.wantStatus)
+			if got := resp.Headers["retry-after"]; got != tt.wantRetryAfter {
+				t.Errorf("retry-after = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && len(fake.inputs(sendMessage)) != 0 {
+				t.Error("message enqueued during maintenance")
+			}
+		})
+	}
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		accept    string
+		versioned bool
+	}{
+		{"bare by default", "", "", false},
+		{"bare for plain json accept", "", "application/json", false},
+		{"versioned by accept header", "", "text/html, application/json; version=1", true},
+		{"bare for another version", "", "application/json; version=2", false},
+		{"versioned by config", "true", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeAWS(t)
+			t.Setenv("RESPONSE_ENVELOPE", tt.config)
+			req := ingestRequest("application/json", `{"tenant_id":"acme","log_id":"log-1","text":"hello"}`)
+			req.Headers["accept"] = tt.accept
+
+			resp := serve(t, req)
+			expectStatus(t, resp, http.StatusAccepted)
+			body := decodeBody(t, resp)
+			data := body
+			if tt.versioned {
+				if body["version"] != float64(1) {
+					t.Fatalf("version = %v, want 1 in %s", body["version"], resp.Body)
+				}
+				data, _ = body["data"].(map[string]any)
+			} else if _, ok := body["version"]; ok {
+				t.Fatalf("bare response has a version: %s", resp.Body)
+			}
+			if data["status"] != "enqueued" || data["tenant_id"] != "acme" || data["log_id"] != "log-1" {
+				t.Errorf("response = %s, want the enqueue response", resp.Body)
+			}
+		})
+	}
+}
+
+func TestQueueInputAttributes(t *testing.T) {
+	fake := newFakeAWS(t)
+	req := ingestRequest("text/plain", "hello")
+	req.Headers["x-tenant-id"] = "acme"
+
+	expectStatus(t, serve(t, req), http.StatusAccepted)
+	sends := fake.inputs(sendMessage)
+	if len(sends) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sends))
+	}
+	attributes := sends[0]["MessageAttributes"].(map[string]any)
+	for name, want := range map[string]string{"tenant_id": "acme", "source": "text_upload"} {
+		attribute, _ := attributes[name].(map[string]any)
+		if attribute["DataType"] != "String" || attribute["StringValue"] != want {
+			t.Errorf("%s attribute = %v, want String %q", name, attribute, want)
+		}
+	}
+}
+
+func TestClientCannotSetReprocess(t *testing.T) {
+	fake := newFakeAWS(t)
+	resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello","reprocess":true}`))
+	expectStatus(t, resp, http.StatusAccepted)
+
+	messages := fake.sentMessages(t)
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	if _, ok := messages[0]["reprocess"]; ok {
+		t.Errorf("queued message carries a client-set reprocess flag: %v", messages[0])
+	}
+}
+
+func TestTenantSourcePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		strict   bool
+		wantCode int
+	}{
+		{"matching source", "json_upload", true, 0},
+		{"mismatch is logged when lenient", "text_upload", false, 0},
+		{"mismatch is rejected when strict", "text_upload", true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.Settings{
+				TenantSources:      map[string]string{"acme": "json_upload"},
+				TenantSourceStrict: tt.strict,
+			}
+			message := models.NewInternalMessage("acme", "log-1", tt.source, "hello")
+			code, err := checkTenantPolicy(settings, message, "application/json")
+			if code != tt.wantCode || (err != nil) != (tt.wantCode != 0) {
+				t.Errorf("checkTenantPolicy = %d, %v; want %d", code, err, tt.wantCode)
+			}
+		})
+	}
+
+	other := models.NewInternalMessage("globex", "log-1", "text_upload", "hello")
+	if code, err := checkTenantPolicy(config.Settings{TenantSources: map[string]string{"acme": "json_upload"}, TenantSourceStrict: true}, other, "text/plain"); err != nil {
+		t.Errorf("tenant without an expected source rejected with %d: %v", code, err)
+	}
+}
+
+func TestTenantSourceStrictRequest(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("TENANT_SOURCES", `{"acme":"json_upload"}`)
+	t.Setenv("TENANT_SOURCE_STRICT", "true")
+	req := ingestRequest("text/plain", "hello")
+	req.Headers["x-tenant-id"] = "acme"
+
+	expectStatus(t, serve(t, req), http.StatusBadRequest)
+	if len(fake.inputs(sendMessage)) != 0 {
+		t.Error("message enqueued despite a strict source mismatch")
+	}
+}
+
+func TestTenantKMSKeys(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("ENCRYPT_QUEUE_BODY", "true")
+	t.Setenv("KMS_KEY_ID", "alias/default")
+	t.Setenv("TENANT_KMS_KEYS", `{"acme":"alias/acme","globex":"alias/globex"}`)
+	fake.respond("TrentService.GenerateDataKey", http.StatusOK, map[string]any{
+		"Plaintext":      bytes.Repeat([]byte{7}, 32),
+		"CiphertextBlob": []byte("wrapped"),
+	})
+
+	for _, tenant := range []string{"acme", "globex", "initech"} {
+		resp := serve(t, ingestRequest("application/json", `{"tenant_id":"`+tenant+`","text":"hello"}`))
+		expectStatus(t, resp, http.StatusAccepted)
+	}
+
+	var keys []any
+	for _, input := range fake.inputs("TrentService.GenerateDataKey") {
+		keys = append(keys, input["KeyId"])
+	}
+	want := []any{"alias/acme", "alias/globex", "alias/default"}
+	if len(keys) != len(want) {
+		t.Fatalf("data keys requested for %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("request %d used key %v, want %v", i, keys[i], want[i])
+		}
+	}
+}
+
+// memStore is an in-memory idempotency.Store with the same claim states as
+// DynamoStore, minus expiry.
+type memStore struct {
+	mu     sync.Mutex
+	claims map[string]idempotency.Record
+}
+
+func (s *memStore) Claim(ctx context.Context, key string, record idempotency.Record) (*idempotency.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scoped := idempotency.ScopedKey(record.TenantID, key)
+	if existing, ok := s.claims[scoped]; ok {
+		return &existing, nil
+	}
+	record.Pending = true
+	s.claims[scoped] = record
+	return nil, nil
+}
+
+func (s *memStore) Complete(ctx context.Context, tenantID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scoped := idempotency.ScopedKey(tenantID, key)
+	record := s.claims[scoped]
+	record.Pending = false
+	s.claims[scoped] = record
+	return nil
+}
+
+func (s *memStore) Release(ctx context.Context, tenantID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, idempotency.ScopedKey(tenantID, key))
+	return nil
+}
+
+// useMemStore enables idempotency with a fresh memStore for the rest of the test.
+func useMemStore(t *testing.T) *memStore {
+	t.Helper()
+	store := &memStore{claims: map[string]idempotency.Record{}}
+	t.Setenv("IDEMPOTENCY_TABLE_NAME", "idempotency")
+	previous := newIdempotencyStore
+	newIdempotencyStore = func(config.Settings) idempotency.Store { return store }
+	t.Cleanup(func() { newIdempotencyStore = previous })
+	return store
+}
+
+func idempotentRequest(key string) events.APIGatewayV2HTTPRequest {
+	req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+	req.Headers["idempotency-key"] = key
+	return req
+}
+
+func TestIdempotentRepeat(t *testing.T) {
+	fake := newFakeAWS(t)
+	store := useMemStore(t)
+
+	first := serve(t, idempotentRequest("key-1"))
+	expectStatus(t, first, http.StatusAccepted)
+	if claim := store.claims[idempotency.ScopedKey("acme", "key-1")]; claim.Pending {
+		t.Fatal("claim still pending after a successful enqueue")
+	}
+
+	repeat := serve(t, idempotentRequest("key-1"))
+	expectStatus(t, repeat, http.StatusAccepted)
+	if repeat.Body != first.Body {
+		t.Errorf("repeat body = %s, want the first response %s", repeat.Body, first.Body)
+	}
+	if got := len(fake.inputs(sendMessage)); got != 1 {
+		t.Errorf("SendMessage called %d times, want 1", got)
+	}
+}
+
+func TestIdempotentRepeatWhilePending(t *testing.T) {
+	fake := newFakeAWS(t)
+	store := useMemStore(t)
+	store.claims[idempotency.ScopedKey("acme", "key-1")] = idempotency.Record{TenantID: "acme", LogID: "key-1", Pending: true}
+
+	resp := serve(t, idempotentRequest("key-1"))
+	expectStatus(t, resp, http.StatusConflict)
+	if resp.Headers["retry-after"] == "" {
+		t.Error("409 has no retry-after")
+	}
+	if len(fake.inputs(sendMessage)) != 0 {
+		t.Error("message enqueued while the first request is pending")
+	}
+}
+
+func TestIdempotentEnqueueFailureReleases(t *testing.T) {
+	fake := newFakeAWS(t)
+	store := useMemStore(t)
+	fake.fail(sendMessage, "AWS.SimpleQueueService.InternalError")
+
+	expectStatus(t, serve(t, idempotentRequest("key-1")), http.StatusInternalServerError)
+	if _, ok := store.claims[idempotency.ScopedKey("acme", "key-1")]; ok {
+		t.Fatal("claim kept after a failed enqueue")
+	}
+
+	fake.respond(sendMessage, http.StatusOK, map[string]any{})
+	expectStatus(t, serve(t, idempotentRequest("key-1")), http.StatusAccepted)
+	if got := len(fake.inputs(sendMessage)); got != 2 {
+		t.Errorf("SendMessage called %d times, want the retry to enqueue again", got)
+	}
+}
+
+func TestCanonicalAcrossFormats(t *testing.T) {
+	fake := newFakeAWS(t)
+	requests := []events.APIGatewayV2HTTPRequest{
+		ingestRequest("application/json", `{"text": "hello", "log_id": "log-1", "tenant_id": "acme"}`),
+		ingestRequest("application/x-www-form-urlencoded", "log_id=log-1&tenant_id=acme&text=hello"),
+		ingestRequest("application/xml", "<log><tenant_id>acme</tenant_id><log_id>log-1</log_id><text>hello</text></log>"),
+	}
+	for _, req := range requests {
+		expectStatus(t, serve(t, req), http.StatusAccepted)
+	}
+
+	inputs := fake.inputs(sendMessage)
+	if len(inputs) != len(requests) {
+		t.Fatalf("SendMessage called %d times, want %d", len(inputs), len(requests))
+	}
+	var want []byte
+	for i, input := range inputs {
+		body := input["MessageBody"].(string)
+		var message models.InternalMessage
+		if err := json.Unmarshal([]byte(body), &message); err != nil {
+			t.Fatal(err)
+		}
+		if canonical, _ := message.Canonical(); string(canonical) != body {
+			t.Errorf("request %d enqueued %s, want canonical %s", i, body, canonical)
+		}
+		// Only the per-request and per-format fields may differ.
+		message.Source, message.TraceID, message.ReceivedAt = "", "", time.Time{}
+		normalized, _ := message.Canonical()
+		if want == nil {
+			want = normalized
+		} else if !bytes.Equal(normalized, want) {
+			t.Errorf("request %d enqueued %s, want %s", i, normalized, want)
+		}
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	body := `{"tenantid":"acme","tenant_id":"acme","text":"hello","txt":"typo"}`
+	tests := []struct {
+		name       string
+		strict     string
+		wantStatus int
+	}{
+		{"lenient by default", "", http.StatusAccepted},
+		{"strict rejects unknown fields", "true", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("STRICT_JSON", tt.strict)
+
+			resp := serve(t, ingestRequest("application/json", body))
+			expectStatus(t, resp, tt.wantStatus)
+			if tt.wantStatus == http.StatusBadRequest {
+				if got := decodeBody(t, resp)["error"]; got != "unknown fields: tenantid, txt" {
+					t.Errorf("error = %v, want the unknown fields listed", got)
+				}
+				if len(fake.inputs(sendMessage)) != 0 {
+					t.Error("message enqueued despite unknown fields")
+				}
+			}
+		})
+	}
+}
+
+func TestStrictJSONAcceptsKnownFields(t *testing.T) {
+	newFakeAWS(t)
+	t.Setenv("STRICT_JSON", "true")
+	expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello","log_id":"log-1"}`)), http.StatusAccepted)
+}
+
+// gzipped compresses data.
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodedBodyLimit(t *testing.T) {
+	const limit = 1024
+	small := []byte(`{"tenant_id":"acme","text":"hello"}`)
+	bomb := gzipped(t, bytes.Repeat([]byte{'a'}, 10<<20))
+	tests := []struct {
+		name       string
+		body       []byte
+		gzip       bool
+		wantStatus int
+	}{
+		{"base64 within the limit", small, false, http.StatusAccepted},
+		{"oversized base64", bytes.Repeat([]byte{'a'}, limit+1), false, http.StatusRequestEntityTooLarge},
+		{"gzip within the limit", gzipped(t, small), true, http.StatusAccepted},
+		{"gzip bomb", bomb, true, http.StatusRequestEntityTooLarge},
+		{"corrupt gzip", small, true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAX_DECODED_BODY_BYTES", strconv.Itoa(limit))
+			req := ingestRequest("application/json", base64.StdEncoding.EncodeToString(tt.body))
+			req.IsBase64Encoded = true
+			if tt.gzip {
+				req.Headers["content-encoding"] = "gzip"
+			}
+
+			expectStatus(t, serve(t, req), tt.wantStatus)
+			if tt.wantStatus == http.StatusAccepted {
+				if got := fake.sentMessages(t)[0]["text"]; got != "hello" {
+					t.Errorf("enqueued text = %v, want hello", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodedBodyLimitDefault(t *testing.T) {
+	newFakeAWS(t)
+	// MAX_DECODED_BODY_BYTES is unset, so the default bound applies.
+	bomb := gzipped(t, bytes.Repeat([]byte{'a'}, 11<<20))
+	req := ingestRequest("application/json", base64.StdEncoding.EncodeToString(bomb))
+	req.IsBase64Encoded = true
+	req.Headers["content-encoding"] = "gzip"
+	expectStatus(t, serve(t, req), http.StatusRequestEntityTooLarge)
+}
+
+func TestReadLimitedStopsAtLimit(t *testing.T) {
+	// An endless reader would never finish without the bound.
+	reader := io.MultiReader(strings.NewReader("x"), neverEnding('a'))
+	if _, err := readLimited(reader, 1<<20); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("readLimited error = %v, want errBodyTooLarge", err)
+	}
+}
+
+// neverEnding is a reader that yields its byte forever.
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestIdempotencyKeyScopedToTenant(t *testing.T) {
+	fake := newFakeAWS(t)
+	useMemStore(t)
+
+	for _, tenant := range []string{"acme", "globex"} {
+		req := ingestRequest("application/json", `{"tenant_id":"`+tenant+`","text":"hello"}`)
+		req.Headers["idempotency-key"] = "shared-key"
+		expectStatus(t, serve(t, req), http.StatusAccepted)
+	}
+
+	messages := fake.sentMessages(t)
+	if len(messages) != 2 {
+		t.Fatalf("enqueued %d messages, want one per tenant", len(messages))
+	}
+	if messages[0]["tenant_id"] != "acme" || messages[1]["tenant_id"] != "globex" {
+		t.Errorf("enqueued tenants %v and %v, want acme and globex", messages[0]["tenant_id"], messages[1]["tenant_id"])
+	}
+}
+
+func TestFormUpload(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"urlencoded fields", "tenant_id=acme&text=call+me%3F+555-123-4567", http.StatusAccepted},
+		{"missing tenant_id", "text=hello", http.StatusBadRequest},
+		{"missing text", "tenant_id=acme", http.StatusBadRequest},
+		{"invalid encoding", "tenant_id=acme&text=%zz", http.StatusBadRequest},
+		{"over the size limit", "tenant_id=acme&text=" + strings.Repeat("a", 64), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAX_FORM_BYTES", "64")
+
+			expectStatus(t, serve(t, ingestRequest("application/x-www-form-urlencoded", tt.body)), tt.wantStatus)
+			messages := fake.sentMessages(t)
+			if tt.wantStatus != http.StatusAccepted {
+				if len(messages) != 0 {
+					t.Error("rejected form was enqueued")
+				}
+				return
+			}
+			if len(messages) != 1 {
+				t.Fatalf("enqueued %d messages, want 1", len(messages))
+			}
+			if messages[0]["tenant_id"] != "acme" || messages[0]["text"] != "call me? 555-123-4567" || messages[0]["source"] != "form_upload" {
+				t.Errorf("enqueued %v, want the decoded form fields from form_upload", messages[0])
+			}
+		})
+	}
+}
+
+func TestAmznTraceID(t *testing.T) {
+	fake := newFakeAWS(t)
+	header := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+
+	withHeader := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+	withHeader.Headers["x-amzn-trace-id"] = header
+	expectStatus(t, serve(t, withHeader), http.StatusAccepted)
+	expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)), http.StatusAccepted)
+
+	messages := fake.sentMessages(t)
+	if got := messages[0]["amzn_trace_id"]; got != header {
+		t.Errorf("amzn_trace_id = %v, want %q", got, header)
+	}
+	if _, ok := messages[1]["amzn_trace_id"]; ok {
+		t.Error("amzn_trace_id set without the header")
+	}
+	if messages[0]["trace_id"] == "" || messages[0]["trace_id"] == header {
+		t.Errorf("trace_id = %v, want our own ID alongside the AWS header", messages[0]["trace_id"])
+	}
+}
+
+func TestRequireContentLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     string
+		wantStatus int
+	}{
+		{"present", "35", http.StatusAccepted},
+		{"absent", "", http.StatusLengthRequired},
+		{"zero", "0", http.StatusLengthRequired},
+		{"not a number", "abc", http.StatusLengthRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeAWS(t)
+			t.Setenv("REQUIRE_CONTENT_LENGTH", "true")
+			req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+			if tt.length != "" {
+				req.Headers["content-length"] = tt.length
+			}
+			expectStatus(t, serve(t, req), tt.wantStatus)
+		})
+	}
+}
+
+func TestContentLengthOptionalByDefault(t *testing.T) {
+	newFakeAWS(t)
+	expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)), http.StatusAccepted)
+}
+
+func TestIdempotentRepeatReplaysExactResponse(t *testing.T) {
+	newFakeAWS(t)
+	useMemStore(t)
+
+	first := serve(t, idempotentRequest("key-1"))
+	expectStatus(t, first, http.StatusAccepted)
+	original := decodeBody(t, first)
+	if original["log_id"] != "key-1" || original["trace_id"] == "" {
+		t.Fatalf("first response = %v, want the generated log_id and trace_id", original)
+	}
+
+	// A retry generates a new trace_id internally, but must not expose it.
+	for i := 0; i < 2; i++ {
+		repeat := serve(t, idempotentRequest("key-1"))
+		if repeat.Body != first.Body {
+			t.Errorf("repeat %d body = %s, want byte-identical %s", i, repeat.Body, first.Body)
+		}
+	}
+}
+
+func TestIdempotentRepeatOfLegacyClaim(t *testing.T) {
+	fake := newFakeAWS(t)
+	store := useMemStore(t)
+	store.claims[idempotency.ScopedKey("acme", "key-1")] = idempotency.Record{TenantID: "acme", LogID: "log-old"}
+
+	resp := serve(t, idempotentRequest("key-1"))
+	expectStatus(t, resp, http.StatusAccepted)
+	body := decodeBody(t, resp)
+	if body["log_id"] != "log-old" || body["tenant_id"] != "acme" || body["status"] != "enqueued" {
+		t.Errorf("response = %v, want one rebuilt from the stored IDs", body)
+	}
+	if len(fake.inputs(sendMessage)) != 0 {
+		t.Error("repeat of a legacy claim was enqueued")
+	}
+}
+
+func TestTenantContentTypes(t *testing.T) {
+	tests := []struct {
+		tenant      string
+		contentType string
+		wantStatus  int
+	}{
+		{"acme", "application/json", http.StatusAccepted},
+		{"acme", "text/plain", http.StatusUnsupportedMediaType},
+		{"globex", "text/plain", http.StatusAccepted},
+		{"globex", "application/json", http.StatusUnsupportedMediaType},
+		{"initech", "text/plain", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant+" "+tt.contentType, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("TENANT_CONTENT_TYPES", `{"acme":["application/json"],"globex":["text/plain"]}`)
+			req := ingestRequest(tt.contentType, "hello")
+			if tt.contentType == "application/json" {
+				req.Body = `{"tenant_id":"` + tt.tenant + `","text":"hello"}`
+			}
+			req.Headers["x-tenant-id"] = tt.tenant
+
+			expectStatus(t, serve(t, req), tt.wantStatus)
+			if enqueued := len(fake.inputs(sendMessage)) > 0; enqueued != (tt.wantStatus == http.StatusAccepted) {
+				t.Errorf("enqueued = %v for status %d", enqueued, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestExplainRedaction(t *testing.T) {
+	body := `{"tenant_id":"acme","text":"mail a@example.com or call 555-1234"}`
+	tests := []struct {
+		name  string
+		query map[string]string
+		want  []any
+	}{
+		{"queued", map[string]string{"explain": "true"}, []any{"email", "phone"}},
+		{"synchronous", map[string]string{"explain": "true", "sync": "true"}, []any{"email", "phone"}},
+		{"not requested", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeAWS(t)
+			t.Setenv("SYNC_MAX_TEXT_BYTES", "1024")
+			req := ingestRequest("application/json", body)
+			req.QueryStringParameters = tt.query
+
+			resp := serve(t, req)
+			if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, resp.Body)
+			}
+			got, _ := decodeBody(t, resp)["redaction_rules"].([]any)
+			if len(got) != len(tt.want) {
+				t.Fatalf("redaction_rules = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("redaction_rules = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAmznTraceIDSentAsAWSTraceHeader(t *testing.T) {
+	fake := newFakeAWS(t)
+	header := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+	req.Headers["x-amzn-trace-id"] = header
+	expectStatus(t, serve(t, req), http.StatusAccepted)
+	expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)), http.StatusAccepted)
+
+	inputs := fake.inputs(sendMessage)
+	system, _ := inputs[0]["MessageSystemAttributes"].(map[string]any)
+	traceHeader, _ := system["AWSTraceHeader"].(map[string]any)
+	if traceHeader["StringValue"] != header {
+		t.Errorf("AWSTraceHeader = %v, want %q", system["AWSTraceHeader"], header)
+	}
+	if _, ok := inputs[1]["MessageSystemAttributes"]; ok {
+		t.Error("MessageSystemAttributes sent without an x-amzn-trace-id header")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		header     string
+		wantStatus int
+		want       string
+		wantDelay  any
+	}{
+		{"", http.StatusAccepted, "normal", nil},
+		{"high", http.StatusAccepted, "high", nil},
+		{" Normal ", http.StatusAccepted, "normal", nil},
+		{"low", http.StatusAccepted, "low", float64(30)},
+		{"urgent", http.StatusBadRequest, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run("x-priority "+tt.header, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("LOW_PRIORITY_DELAY_SECONDS", "30")
+			req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+			if tt.header != "" {
+				req.Headers["x-priority"] = tt.header
+			}
+
+			expectStatus(t, serve(t, req), tt.wantStatus)
+			if tt.wantStatus != http.StatusAccepted {
+				if len(fake.inputs(sendMessage)) != 0 {
+					t.Error("message enqueued with an invalid priority")
+				}
+				return
+			}
+			if got := fake.sentMessages(t)[0]["priority"]; got != tt.want {
+				t.Errorf("priority = %v, want %s", got, tt.want)
+			}
+			if got := fake.inputs(sendMessage)[0]["DelaySeconds"]; got != tt.wantDelay {
+				t.Errorf("DelaySeconds = %v, want %v", got, tt.wantDelay)
+			}
+		})
+	}
+}
+
+// batchBody builds a body of n records in the given multi-record format.
+func batchBody(contentType string, n int) string {
+	var b strings.Builder
+	switch contentType {
+	case ndjsonContentType:
+		for i := 0; i < n; i++ {
+			b.WriteString(`{"tenant_id":"acme","text":"hello"}` + "\n")
+		}
+	case csvContentType:
+		b.WriteString("tenant_id,text\n")
+		for i := 0; i < n; i++ {
+			b.WriteString("acme,hello\n")
+		}
+	default:
+		items := make([]string, n)
+		for i := range items {
+			items[i] = `{"format":"text","text":"hello"}`
+		}
+		b.WriteString(`{"tenant_id":"acme","items":[` + strings.Join(items, ",") + `]}`)
+	}
+	return b.String()
+}
+
+func TestMaxBatchRecords(t *testing.T) {
+	for _, contentType := range []string{ndjsonContentType, csvContentType, "application/json"} {
+		t.Run(contentType, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAX_BATCH_RECORDS", "3")
+			t.Setenv("ITEM_ENVELOPES", "true")
+
+			expectStatus(t, serve(t, ingestRequest(contentType, batchBody(contentType, 3))), http.StatusAccepted)
+			resp := serve(t, ingestRequest(contentType, batchBody(contentType, 4)))
+			expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+			if got := decodeBody(t, resp)["error"]; got != "batch exceeds 3 records per request" {
+				t.Errorf("error = %v, want the combined limit named", got)
+			}
+
+			var entries int
+			for _, input := range fake.inputs(sendMessageBatch) {
+				entries += len(input["Entries"].([]any))
+			}
+			if entries != 3 {
+				t.Errorf("sent %d entries, want only the 3 from the batch within the limit", entries)
+			}
+		})
+	}
+}
+
+func TestMaxBatchRecordsCountsInvalidRows(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("MAX_BATCH_RECORDS", "3")
+
+	body := `{"tenant_id":"acme","text":"hello"}` + "\n" + strings.Repeat("{not json\n", 3)
+	expectStatus(t, serve(t, ingestRequest(ndjsonContentType, body)), http.StatusRequestEntityTooLarge)
+	if len(fake.inputs(sendMessageBatch)) != 0 {
+		t.Error("rows enqueued from a batch over the limit")
+	}
+}
+
+func TestRetryTokenOnTransientFailure(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("ENQUEUE_RETRY_AFTER_SECONDS", "5")
+	fake.fail(sendMessage, "AWS.SimpleQueueService.InternalError")
+
+	resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+	expectStatus(t, resp, http.StatusServiceUnavailable)
+	if got := resp.Headers["retry-after"]; got != "5" {
+		t.Errorf("retry-after = %q, want 5", got)
+	}
+	token, _ := decodeBody(t, resp)["retry_token"].(string)
+	if _, err := uuid.Parse(token); err != nil {
+		t.Fatalf("retry_token = %q, want a UUID", token)
+	}
+
+	// The client-supplied key is echoed back rather than replaced.
+	keyed := idempotentRequest("client-key")
+	if got := decodeBody(t, serve(t, keyed))["retry_token"]; got != "client-key" {
+		t.Errorf("retry_token = %v, want the Idempotency-Key", got)
+	}
+
+	// Retrying with the token enqueues the message under it.
+	fake.respond(sendMessage, http.StatusOK, map[string]any{})
+	retry := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+	retry.Headers["idempotency-key"] = token
+	expectStatus(t, serve(t, retry), http.StatusAccepted)
+	if got := fake.sentMessages(t)[2]["log_id"]; got != token {
+		t.Errorf("retried log_id = %v, want the retry token", got)
+	}
+}
+
+func TestTransientFailureWithoutRetryAfter(t *testing.T) {
+	fake := newFakeAWS(t)
+	fake.fail(sendMessage, "AWS.SimpleQueueService.InternalError")
+
+	resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+	expectStatus(t, resp, http.StatusInternalServerError)
+	if _, ok := decodeBody(t, resp)["retry_token"]; ok {
+		t.Error("retry_token returned without ENQUEUE_RETRY_AFTER_SECONDS")
+	}
+}
+
+// fakeUploads is an objectPutAPI recording each object it stores.
+type fakeUploads struct {
+	objects map[string]string
+	err     error
+}
+
+func (f *fakeUploads) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, _ := io.ReadAll(params.Body)
+	f.objects[*params.Bucket+"/"+*params.Key] = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestOffloadText(t *testing.T) {
+	uploads := &fakeUploads{objects: map[string]string{}}
+	message := models.NewInternalMessage("acme", "log-1", "json_upload", "a long text")
+	message.TraceID = "trace-1"
+
+	if err := offloadText(context.Background(), uploads, "acme-ingest-payloads", &message); err != nil {
+		t.Fatalf("offloadText: %v", err)
+	}
+	const key = "payloads/acme/log-1/trace-1"
+	if uploads.objects["acme-ingest-payloads/"+key] != "a long text" {
+		t.Errorf("objects = %v, want the text under %s", uploads.objects, key)
+	}
+	if message.Text != "" || message.BodyRef == nil || *message.BodyRef != (models.BodyRef{Bucket: "acme-ingest-payloads", Key: key}) {
+		t.Errorf("message text %q, body_ref %+v; want the text replaced by a reference", message.Text, message.BodyRef)
+	}
+
+	failed := models.NewInternalMessage("acme", "log-2", "json_upload", "a long text")
+	if err := offloadText(context.Background(), &fakeUploads{err: errors.New("AccessDenied")}, "acme-ingest-payloads", &failed); err == nil {
+		t.Fatal("offloadText succeeded when the upload failed")
+	}
+	if failed.Text != "a long text" || failed.BodyRef != nil {
+		t.Error("failed upload changed the message")
+	}
+}
+
+func TestOversizedTextOffloaded(t *testing.T) {
+	text := strings.Repeat("a", 1000)
+	for _, tt := range []struct {
+		bucket     string
+		wantStatus int
+	}{{"", http.StatusRequestEntityTooLarge}, {"acme-ingest-payloads", http.StatusAccepted}} {
+		t.Run("bucket="+tt.bucket, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAX_TEXT_BYTES", "512")
+			t.Setenv("PAYLOAD_BUCKET", tt.bucket)
+
+			expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"`+text+`"}`)), tt.wantStatus)
+			messages := fake.sentMessages(t)
+			if tt.wantStatus != http.StatusAccepted {
+				if len(messages) != 0 {
+					t.Error("oversized message enqueued without a bucket")
+				}
+				return
+			}
+			if len(messages) != 1 {
+				t.Fatalf("enqueued %d messages, want 1", len(messages))
+			}
+			ref, _ := messages[0]["body_ref"].(map[string]any)
+			key, _ := ref["key"].(string)
+			if messages[0]["text"] != "" || ref["bucket"] != tt.bucket || !strings.HasPrefix(key, "payloads/acme/") {
+				t.Errorf("enqueued %v, want a body_ref into %s instead of the text", messages[0], tt.bucket)
+			}
+		})
+	}
+}
+
+func TestLogIDPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		header      string
+		want        string
+	}{
+		{"header over json body", "application/json", `{"tenant_id":"acme","log_id":"body-id","text":"hello"}`, "key-1", "key-1"},
+		{"json body", "application/json", `{"tenant_id":"acme","log_id":"body-id","text":"hello"}`, "", "body-id"},
+		{"header over form body", "application/x-www-form-urlencoded", "tenant_id=acme&log_id=body-id&text=hello", "key-1", "key-1"},
+		{"form body", "application/x-www-form-urlencoded", "tenant_id=acme&log_id=body-id&text=hello", "", "body-id"},
+		{"header for plain text", "text/plain", "hello", "key-1", "key-1"},
+		{"ndjson ignores the header", ndjsonContentType, `{"tenant_id":"acme","log_id":"line-id","text":"hello"}`, "key-1", "line-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			req := ingestRequest(tt.contentType, tt.body)
+			req.Headers["x-tenant-id"] = "acme"
+			if tt.header != "" {
+				req.Headers["idempotency-key"] = tt.header
+			}
+
+			serve(t, req)
+			logIDs := sentLogIDs(t, fake)
+			if len(logIDs) != 1 || logIDs[0] != tt.want {
+				t.Errorf("enqueued log_ids %v, want [%s]", logIDs, tt.want)
+			}
+		})
+	}
+
+	t.Run("generated without either", func(t *testing.T) {
+		fake := newFakeAWS(t)
+		serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+		logIDs := sentLogIDs(t, fake)
+		if len(logIDs) != 1 {
+			t.Fatalf("enqueued %d messages, want 1", len(logIDs))
+		}
+		if _, err := uuid.Parse(logIDs[0]); err != nil {
+			t.Errorf("log_id %q is not a UUID", logIDs[0])
+		}
+	})
+}
+
+// sentQueued returns every message sent, singly or in batches.
+func sentQueued(t *testing.T, fake *fakeAWS) []models.InternalMessage {
+	t.Helper()
+	var bodies []string
+	for _, input := range fake.inputs(sendMessage) {
+		bodies = append(bodies, input["MessageBody"].(string))
+	}
+	for _, input := range fake.inputs(sendMessageBatch) {
+		for _, entry := range input["Entries"].([]any) {
+			bodies = append(bodies, entry.(map[string]any)["MessageBody"].(string))
+		}
+	}
+	var messages []models.InternalMessage
+	for _, body := range bodies {
+		var message models.InternalMessage
+		if err := json.Unmarshal([]byte(body), &message); err != nil {
+			t.Fatalf("queued body is not JSON: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// sentLogIDs returns the log_id of every message sent, singly or in batches.
+func sentLogIDs(t *testing.T, fake *fakeAWS) []string {
+	t.Helper()
+	var logIDs []string
+	for _, message := range sentQueued(t, fake) {
+		logIDs = append(logIDs, message.LogID)
+	}
+	return logIDs
+}
+
+// resetQueueDepth clears the sampled queue depth before and after the test.
+func resetQueueDepth(t *testing.T) {
+	t.Helper()
+	queueDepth = depthCache{}
+	t.Cleanup(func() { queueDepth = depthCache{} })
+}
+
+const getQueueAttributes = "AmazonSQS.GetQueueAttributes"
+
+func TestBackpressure(t *testing.T) {
+	tests := []struct {
+		name           string
+		threshold      string
+		depth          string
+		wantStatus     string
+		wantRetryAfter string
+	}{
+		{"disabled", "", "5000", "enqueued", ""},
+		{"below the threshold", "1000", "999", "enqueued", ""},
+		{"at the threshold", "1000", "1000", "throttled_accepted", "7"},
+		{"depth not a number", "1000", "lots", "enqueued", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			resetQueueDepth(t)
+			t.Setenv("BACKPRESSURE_QUEUE_DEPTH", tt.threshold)
+			t.Setenv("BACKPRESSURE_RETRY_AFTER_SECONDS", "7")
+			fake.respond(getQueueAttributes, http.StatusOK, map[string]any{
+				"Attributes": map[string]string{"ApproximateNumberOfMessages": tt.depth},
+			})
+
+			resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+			expectStatus(t, resp, http.StatusAccepted)
+			if got := decodeBody(t, resp)["status"]; got != tt.wantStatus {
+				t.Errorf("status = %v, want %s", got, tt.wantStatus)
+			}
+			if got := resp.Headers["retry-after"]; got != tt.wantRetryAfter {
+				t.Errorf("retry-after = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if len(fake.inputs(sendMessage)) != 1 {
+				t.Error("message not enqueued")
+			}
+		})
+	}
+}
+
+func TestBackpressureSampleFailureDoesNotThrottle(t *testing.T) {
+	fake := newFakeAWS(t)
+	resetQueueDepth(t)
+	t.Setenv("BACKPRESSURE_QUEUE_DEPTH", "1")
+	fake.fail(getQueueAttributes, "AccessDenied")
+
+	resp := serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+	expectStatus(t, resp, http.StatusAccepted)
+	if got := decodeBody(t, resp)["status"]; got != "enqueued" {
+		t.Errorf("status = %v, want enqueued", got)
+	}
+}
+
+func TestBackpressureDepthIsCached(t *testing.T) {
+	fake := newFakeAWS(t)
+	resetQueueDepth(t)
+	t.Setenv("BACKPRESSURE_QUEUE_DEPTH", "1000")
+	fake.respond(getQueueAttributes, http.StatusOK, map[string]any{
+		"Attributes": map[string]string{"ApproximateNumberOfMessages": "1500"},
+	})
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{start, start.Add(depthCacheTTL - time.Second), start.Add(depthCacheTTL)} {
+		setNow(t, at)
+		serve(t, ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+	}
+	if got := len(fake.inputs(getQueueAttributes)); got != 2 {
+		t.Errorf("sampled the queue depth %d times, want once per %s", got, depthCacheTTL)
+	}
+}
+
+const putItem = "DynamoDB_20120810.PutItem"
+
+// syncRequest is a JSON upload asking for synchronous processing.
+func syncRequest(text string) events.APIGatewayV2HTTPRequest {
+	req := ingestRequest("application/json", `{"tenant_id":"acme","log_id":"log-1","text":"`+text+`"}`)
+	req.Headers["x-processing-mode"] = "sync"
+	return req
+}
+
+func TestSyncProcessing(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("SYNC_MAX_TEXT_BYTES", "1024")
+
+	resp := serve(t, syncRequest("call 555-1234"))
+	expectStatus(t, resp, http.StatusOK)
+	body := decodeBody(t, resp)
+	if body["status"] != "processed" || body["log_id"] != "log-1" || body["modified_data"] != "call [REDACTED]" {
+		t.Errorf("response = %v, want the processed, redacted record", body)
+	}
+	if _, ok := body["original_text"]; ok {
+		t.Error("response includes original_text")
+	}
+	if len(fake.inputs(sendMessage)) != 0 {
+		t.Error("sync request was enqueued")
+	}
+	puts := fake.inputs(putItem)
+	if len(puts) != 1 {
+		t.Fatalf("PutItem calls = %d, want 1", len(puts))
+	}
+	if puts[0]["ConditionExpression"] != "attribute_not_exists(tenant_id) AND attribute_not_exists(log_id)" {
+		t.Errorf("ConditionExpression = %v, want the worker's dedup condition", puts[0]["ConditionExpression"])
+	}
+}
+
+func TestSyncProcessingDuplicate(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("SYNC_MAX_TEXT_BYTES", "1024")
+	fake.fail(putItem, "ConditionalCheckFailedException")
+
+	expectStatus(t, serve(t, syncRequest("hello")), http.StatusConflict)
+}
+
+func TestSyncProcessingRejections(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBytes   string
+		req        func() events.APIGatewayV2HTTPRequest
+		wantStatus int
+	}{
+		{"not enabled", "", func() events.APIGatewayV2HTTPRequest { return syncRequest("hello") }, http.StatusBadRequest},
+		{"text too long", "4", func() events.APIGatewayV2HTTPRequest { return syncRequest("hello") }, http.StatusRequestEntityTooLarge},
+		{"batch", "1024", func() events.APIGatewayV2HTTPRequest {
+			req := ingestRequest(ndjsonContentType, `{"tenant_id":"acme","text":"hello"}`)
+			req.Headers["x-processing-mode"] = "sync"
+			return req
+		}, http.StatusBadRequest},
+		{"invalid mode", "1024", func() events.APIGatewayV2HTTPRequest {
+			req := syncRequest("hello")
+			req.Headers["x-processing-mode"] = "later"
+			return req
+		}, http.StatusBadRequest},
+		{"invalid query", "1024", func() events.APIGatewayV2HTTPRequest {
+			req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+			req.QueryStringParameters = map[string]string{"sync": "yes"}
+			return req
+		}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("SYNC_MAX_TEXT_BYTES", tt.maxBytes)
+
+			expectStatus(t, serve(t, tt.req()), tt.wantStatus)
+			if len(fake.inputs(putItem)) != 0 || len(fake.inputs(sendMessage)) != 0 {
+				t.Error("rejected request was processed or enqueued")
+			}
+		})
+	}
+}
+
+func TestProcessingModeHeaderWinsOverQuery(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("SYNC_MAX_TEXT_BYTES", "1024")
+	req := syncRequest("hello")
+	req.Headers["x-processing-mode"] = "async"
+	req.QueryStringParameters = map[string]string{"sync": "true"}
+
+	expectStatus(t, serve(t, req), http.StatusAccepted)
+	if len(fake.inputs(sendMessage)) != 1 || len(fake.inputs(putItem)) != 0 {
+		t.Error("X-Processing-Mode: async did not override ?sync=true")
+	}
+}
+
+func TestMixedFormatEnvelope(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("ITEM_ENVELOPES", "true")
+	body := `{"tenant_id":"acme","items":[
+		{"format":"text","text":"User 555-0199 signed up"},
+		{"format":"json","log_id":"order-7","data":{"email": "john@example.com", "total": 42}},
+		{"format":"xml","text":"<a/>"},
+		{"format":"json","data":[1,2]}
+	]}`
+
+	resp := serve(t, ingestRequest("application/json", body))
+	expectStatus(t, resp, http.StatusMultiStatus)
+	result := decodeBody(t, resp)
+	if result["enqueued"] != float64(2) {
+		t.Errorf("enqueued = %v, want 2", result["enqueued"])
+	}
+	failed, _ := json.Marshal(result["failed"])
+	if want := `[{"error":"invalid format \"xml\": use text or json","line":3},{"error":"data must be a JSON object","line":4}]`; string(failed) != want {
+		t.Errorf("failed = %s, want %s", failed, want)
+	}
+
+	var messages []models.InternalMessage
+	for _, input := range fake.inputs(sendMessageBatch) {
+		for _, entry := range input["Entries"].([]any) {
+			var message models.InternalMessage
+			if err := json.Unmarshal([]byte(entry.(map[string]any)["MessageBody"].(string)), &message); err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(messages))
+	}
+	if messages[0].Text != "User 555-0199 signed up" || messages[0].Source != "envelope_upload" || messages[0].TenantID != "acme" {
+		t.Errorf("text item enqueued as %+v", messages[0])
+	}
+	if messages[1].Text != `{"email":"john@example.com","total":42}` || messages[1].LogID != "order-7" || messages[1].Source != "envelope_upload" {
+		t.Errorf("json item enqueued as %+v, want its data as compact JSON text", messages[1])
+	}
+}
+
+func TestEnvelopeRequiresItemEnvelopes(t *testing.T) {
+	fake := newFakeAWS(t)
+	expectStatus(t, serve(t, ingestRequest("application/json", `{"tenant_id":"acme","items":[{"format":"text","text":"hello"}]}`)), http.StatusBadRequest)
+	if len(fake.inputs(sendMessageBatch)) != 0 {
+		t.Error("envelope enqueued without ITEM_ENVELOPES")
+	}
+}
+
+func TestBatchEntryIDsAreUnique(t *testing.T) {
+	fake := newFakeAWS(t)
+	expectStatus(t, serve(t, ingestRequest(ndjsonContentType, batchBody(ndjsonContentType, 25))), http.StatusAccepted)
+
+	calls := fake.inputs(sendMessageBatch)
+	if len(calls) != 3 {
+		t.Fatalf("SendMessageBatch calls = %d, want 3 for 25 records", len(calls))
+	}
+	seen := map[string]bool{}
+	for i, call := range calls {
+		idsInCall := map[string]bool{}
+		for _, entry := range call["Entries"].([]any) {
+			id := entry.(map[string]any)["Id"].(string)
+			if idsInCall[id] {
+				t.Errorf("call %d reuses entry id %s", i, id)
+			}
+			idsInCall[id] = true
+			seen[id] = true
+		}
+	}
+	if len(seen) != 25 {
+		t.Errorf("%d distinct entry ids across the batch, want 25", len(seen))
+	}
+}
+
+func TestCheckEntryIDs(t *testing.T) {
+	entry := func(id string) sqstypes.SendMessageBatchRequestEntry {
+		return sqstypes.SendMessageBatchRequestEntry{Id: stringPtr(id)}
+	}
+	if err := checkEntryIDs([]sqstypes.SendMessageBatchRequestEntry{entry("0"), entry("1"), entry("2")}); err != nil {
+		t.Errorf("unique ids rejected: %v", err)
+	}
+	err := checkEntryIDs([]sqstypes.SendMessageBatchRequestEntry{entry("0"), entry("1"), entry("0")})
+	if err == nil || err.Error() != `duplicate batch entry id "0"` {
+		t.Errorf("checkEntryIDs = %v, want the duplicate id named", err)
+	}
+}
+
+func TestSyncQueryParameter(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantStatus int
+		wantPuts   int
+	}{
+		{"within the limit", "call 555-1234", http.StatusOK, 1},
+		{"at the limit", strings.Repeat("a", 16), http.StatusOK, 1},
+		{"over the limit", strings.Repeat("a", 17), http.StatusRequestEntityTooLarge, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("SYNC_MAX_TEXT_BYTES", "16")
+			req := ingestRequest("application/json", `{"tenant_id":"acme","text":"`+tt.text+`"}`)
+			req.QueryStringParameters = map[string]string{"sync": "true"}
+
+			resp := serve(t, req)
+			expectStatus(t, resp, tt.wantStatus)
+			if got := len(fake.inputs(putItem)); got != tt.wantPuts {
+				t.Errorf("PutItem calls = %d, want %d", got, tt.wantPuts)
+			}
+			if len(fake.inputs(sendMessage)) != 0 {
+				t.Error("sync request was enqueued")
+			}
+			if tt.wantStatus == http.StatusOK && decodeBody(t, resp)["status"] != "processed" {
+				t.Errorf("response = %s, want the processed record", resp.Body)
+			}
+		})
+	}
+}
+
+func TestMaxHeaderCount(t *testing.T) {
+	// withHeaders adds n x-extra-* headers to a JSON upload.
+	withHeaders := func(n int) events.APIGatewayV2HTTPRequest {
+		req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+		for i := 0; i < n; i++ {
+			req.Headers["x-extra-"+strconv.Itoa(i)] = "1"
+		}
+		return req
+	}
+	base := len(withHeaders(0).Headers)
+	tests := []struct {
+		name       string
+		max        string
+		extra      int
+		wantStatus int
+	}{
+		{"at the limit", strconv.Itoa(base + 5), 5, http.StatusAccepted},
+		{"over the limit", strconv.Itoa(base + 5), 6, http.StatusRequestHeaderFieldsTooLarge},
+		{"unlimited by default", "", 500, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("MAX_HEADER_COUNT", tt.max)
+
+			expectStatus(t, serve(t, withHeaders(tt.extra)), tt.wantStatus)
+			if sent := len(fake.inputs(sendMessage)); tt.wantStatus != http.StatusAccepted && sent != 0 {
+				t.Error("request over the header limit was enqueued")
+			}
+		})
+	}
+}
+
+func TestRegisteredTenants(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered string
+		tenant     string
+		wantStatus int
+	}{
+		{"registered tenant", `["acme","beta"]`, "acme", http.StatusAccepted},
+		{"unregistered tenant", `["acme","beta"]`, "globex", http.StatusForbidden},
+		{"empty registry admits nobody", `[]`, "acme", http.StatusForbidden},
+		{"unset admits any tenant", "", "globex", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("REGISTERED_TENANTS", tt.registered)
+
+			resp := serve(t, ingestRequest("application/json", `{"tenant_id":"`+tt.tenant+`","text":"hello"}`))
+			expectStatus(t, resp, tt.wantStatus)
+			sent := len(fake.inputs(sendMessage))
+			if tt.wantStatus == http.StatusForbidden {
+				if sent != 0 || decodeBody(t, resp)["error"] != "unknown tenant" {
+					t.Errorf("response %s with %d messages sent, want an unknown tenant error and nothing sent", resp.Body, sent)
+				}
+			} else if sent != 1 {
+				t.Errorf("sent %d messages, want 1", sent)
+			}
+		})
+	}
+}
+
+func TestRegisteredTenantsInBatch(t *testing.T) {
+	fake := newFakeAWS(t)
+	t.Setenv("REGISTERED_TENANTS", `["acme"]`)
+	body := `{"tenant_id":"acme","text":"hello"}` + "\n" + `{"tenant_id":"globex","text":"hello"}`
+
+	resp := serve(t, ingestRequest(ndjsonContentType, body))
+	expectStatus(t, resp, http.StatusMultiStatus)
+	failed, _ := json.Marshal(decodeBody(t, resp)["failed"])
+	if string(failed) != `[{"error":"unknown tenant","line":2}]` {
+		t.Errorf("failed = %s, want line 2 rejected as an unknown tenant", failed)
+	}
+	if logIDs := sentLogIDs(t, fake); len(logIDs) != 1 {
+		t.Errorf("sent %d messages, want only the registered tenant's", len(logIDs))
+	}
+}
+
+// keyedRequest is ingestRequest carrying acme's API key.
+func keyedRequest(contentType, body string) events.APIGatewayV2HTTPRequest {
+	req := ingestRequest(contentType, body)
+	req.Headers["x-api-key"] = "k_live_acme"
+	return req
+}
+
+func TestAuthenticationRejectsBadKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"missing key", ""},
+		{"unknown key", "k_live_nope"},
+		{"key prefix", "k_live_ac"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("API_KEYS", `{"k_live_acme":"acme"}`)
+			req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+			if tt.key != "" {
+				req.Headers["x-api-key"] = tt.key
+			}
+
+			resp := serve(t, req)
+			expectStatus(t, resp, http.StatusUnauthorized)
+			if got := decodeBody(t, resp)["error"]; got != "missing or invalid API key" {
+				t.Errorf("error = %v, want missing or invalid API key", got)
+			}
+			if sent := len(sentQueued(t, fake)); sent != 0 {
+				t.Errorf("sent %d messages for a rejected key", sent)
+			}
+		})
+	}
+}
+
+func TestAuthenticatedTenantOverridesBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		header      string
+		wantSent    int
+	}{
+		{"json", "application/json", `{"tenant_id":"globex","text":"hello"}`, "", 1},
+		{"text", "text/plain", "hello", "globex", 1},
+		{"text without tenant header", "text/plain", "hello", "", 1},
+		{"form", "application/x-www-form-urlencoded", "tenant_id=globex&text=hello", "", 1},
+		{"xml", "application/xml", "<log><tenant_id>globex</tenant_id><text>hello</text></log>", "", 1},
+		{"ndjson", ndjsonContentType, `{"tenant_id":"globex","text":"hello"}` + "\n" + `{"tenant_id":"acme","text":"hi"}` + "\n", "", 2},
+		{"csv", csvContentType, "tenant_id,text\nglobex,hello\nacme,hi\n", "", 2},
+		{"envelope", "application/json", `{"tenant_id":"globex","items":[{"format":"text","text":"hello"},{"format":"json","data":{"text":"hi"}}]}`, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			t.Setenv("API_KEYS", `{"k_live_acme":"acme"}`)
+			t.Setenv("ITEM_ENVELOPES", "true")
+			req := keyedRequest(tt.contentType, tt.body)
+			if tt.header != "" {
+				req.Headers["x-tenant-id"] = tt.header
+			}
+
+			expectStatus(t, serve(t, req), http.StatusAccepted)
+			messages := sentQueued(t, fake)
+			if len(messages) != tt.wantSent {
+				t.Fatalf("sent %d messages, want %d", len(messages), tt.wantSent)
+			}
+			for _, message := range messages {
+				if message.TenantID != "acme" {
+					t.Errorf("queued tenant_id = %q, want the key's tenant acme", message.TenantID)
+				}
+			}
+		})
+	}
+}
+
+// failingKeyStore is an apikey.KeyStore whose backend is down.
+type failingKeyStore struct{}
+
+func (failingKeyStore) TenantFor(context.Context, string) (string, error) {
+	return "", errors.New("secrets backend unavailable")
+}
+
+func TestKeyStoreErrorIsServerError(t *testing.T) {
+	fake := newFakeAWS(t)
+	previous := keyStore
+	keyStore = func(config.Settings) apikey.KeyStore { return failingKeyStore{} }
+	t.Cleanup(func() { keyStore = previous })
+
+	resp := serve(t, keyedRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+	expectStatus(t, resp, http.StatusInternalServerError)
+	if _, ok := resp.Headers["www-authenticate"]; ok {
+		t.Error("a backend failure carried an authentication challenge")
+	}
+	if sent := len(sentQueued(t, fake)); sent != 0 {
+		t.Errorf("sent %d messages while authentication was unavailable", sent)
+	}
+}
+
+func TestUnauthorizedChallenge(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"missing key", ""},
+		{"invalid key", "k_live_nope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeAWS(t)
+			t.Setenv("API_KEYS", `{"k_live_acme":"acme"}`)
+			req := ingestRequest("application/json", `{"tenant_id":"acme","text":"hello"}`)
+			if tt.key != "" {
+				req.Headers["x-api-key"] = tt.key
+			}
+
+			resp := serve(t, req)
+			expectStatus(t, resp, http.StatusUnauthorized)
+			if got, want := resp.Headers["www-authenticate"], `ApiKey realm="ingest", header="X-Api-Key"`; got != want {
+				t.Errorf("www-authenticate = %q, want %q", got, want)
+			}
+		})
+	}
+
+	newFakeAWS(t)
+	t.Setenv("API_KEYS", `{"k_live_acme":"acme"}`)
+	resp := serve(t, keyedRequest("application/json", `{"tenant_id":"acme","text":"hello"}`))
+	expectStatus(t, resp, http.StatusAccepted)
+	if _, ok := resp.Headers["www-authenticate"]; ok {
+		t.Error("an authenticated request carried a challenge")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/models"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// handleNDJSON enqueues one message per non-blank line.
+func handleNDJSON(ctx context.Context, req events.APIGatewayV2HTTPRequest, settings config.Settings, body, priority, authTenant string) events.APIGatewayV2HTTPResponse {
+	lines := strings.Split(body, "\n")
+	next := 0
+	return handleBatch(ctx, req, settings, ndjsonContentType, priority, func() (batchRow, bool) {
+		for next < len(lines) {
+			line := strings.TrimSpace(lines[next])
+			next++
+			if line == "" {
+				continue
+			}
+			message, err := parseNDJSONLine(settings, line, authTenant)
+			return batchRow{line: next, message: message, err: err}, true
+		}
+		return batchRow{}, false
+	})
+}
+
+// parseNDJSONLine validates a line the same way as a single JSON upload,
+// including the authenticated tenant overriding tenant_id.
+func parseNDJSONLine(settings config.Settings, line, authTenant string) (models.InternalMessage, error) {
+	var payload models.JSONIngestRequest
+	if err := json.Unmarshal([]byte(line), &payload); err != nil {
+		return models.InternalMessage{}, errors.New("invalid JSON payload")
+	}
+	if settings.StrictJSON {
+		unknown, _ := unknownFields([]byte(line), payload)
+		if len(unknown) > 0 {
+			return models.InternalMessage{}, errors.New("unknown fields: " + strings.Join(unknown, ", "))
+		}
+	}
+	if authTenant != "" {
+		payload.TenantID = authTenant
+	}
+	if payload.TenantID == "" || payload.Text == "" {
+		return models.InternalMessage{}, errors.New("tenant_id and text are required")
+	}
+	logID := payload.LogID
+	if logID == "" {
+		logID = uuid.NewString()
+	}
+	return models.NewInternalMessage(payload.TenantID, logID, "ndjson_upload", payload.Text), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/models"
+)
+
+// objectPutAPI is the subset of the S3 client used to offload texts.
+type objectPutAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// newS3Client uses path-style addressing with a custom endpoint, which
+// LocalStack and most S3 emulators need.
+func newS3Client(settings config.Settings) *s3.Client {
+	return s3.NewFromConfig(settings.AWSConfig, func(o *s3.Options) {
+		o.UsePathStyle = settings.AWSEndpointURL != ""
+	})
+}
+
+// offloadText uploads message.Text to bucket and replaces it with a BodyRef.
+// Keys include the trace ID so a reprocessed log_id never overwrites an
+// object a queued message still points at.
+func offloadText(ctx context.Context, client objectPutAPI, bucket string, message *models.InternalMessage) error {
+	key := "payloads/" + message.TenantID + "/" + message.LogID + "/" + message.TraceID
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        strings.NewReader(message.Text),
+		ContentType: stringPtr("text/plain; charset=utf-8"),
+	})
+	if err != nil {
+		logging.Message(*message).Error("failed to offload payload", "error", err)
+		return errors.New("failed to store payload")
+	}
+	message.BodyRef = &models.BodyRef{Bucket: bucket, Key: key}
+	message.Text = ""
+	return nil
+}
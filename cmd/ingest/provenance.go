@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// callerIdentityAPI is the subset of the STS client used to resolve the account ID.
+type callerIdentityAPI interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// accountCache memoizes the caller's account ID for the life of the container.
+var accountCache struct {
+	sync.Mutex
+	id string
+}
+
+// resolveAccountID returns the cached account ID, calling STS on first use.
+// Failures are not cached so a later request can retry.
+func resolveAccountID(ctx context.Context, client callerIdentityAPI) (string, error) {
+	accountCache.Lock()
+	defer accountCache.Unlock()
+	if accountCache.id != "" {
+		return accountCache.id, nil
+	}
+
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("get caller identity: %w", err)
+	}
+	if out.Account == nil {
+		return "", fmt.Errorf("get caller identity: no account in response")
+	}
+	accountCache.id = *out.Account
+	return accountCache.id, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// fakeSTS answers GetCallerIdentity with each of errs in turn, then account.
+type fakeSTS struct {
+	account string
+	errs    []error
+	calls   int
+}
+
+func (f *fakeSTS) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return &sts.GetCallerIdentityOutput{Account: &f.account}, nil
+}
+
+// resetAccountCache clears the container-wide account ID around a test.
+func resetAccountCache(t *testing.T) {
+	t.Helper()
+	accountCache.id = ""
+	t.Cleanup(func() { accountCache.id = "" })
+}
+
+func TestResolveAccountIDCaches(t *testing.T) {
+	resetAccountCache(t)
+	client := &fakeSTS{account: "123456789012"}
+
+	for i := 0; i < 3; i++ {
+		got, err := resolveAccountID(context.Background(), client)
+		if err != nil {
+			t.Fatalf("resolveAccountID: %v", err)
+		}
+		if got != "123456789012" {
+			t.Errorf("account = %q, want 123456789012", got)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("GetCallerIdentity called %d times, want 1", client.calls)
+	}
+}
+
+func TestResolveAccountIDRetriesFailures(t *testing.T) {
+	resetAccountCache(t)
+	client := &fakeSTS{account: "123456789012", errs: []error{errors.New("throttled")}}
+
+	if _, err := resolveAccountID(context.Background(), client); err == nil {
+		t.Fatal("resolveAccountID succeeded, want the STS error")
+	}
+	got, err := resolveAccountID(context.Background(), client)
+	if err != nil || got != "123456789012" {
+		t.Fatalf("resolveAccountID after a failure = %q, %v; want the account", got, err)
+	}
+	if client.calls != 2 {
+		t.Errorf("GetCallerIdentity called %d times, want 2", client.calls)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/models"
+	"memory-machine/internal/processor"
+	"memory-machine/internal/redaction"
+)
+
+// processSync redacts and persists message inline instead of enqueueing it,
+// returning the stored record. It uses the worker's conditional write, so a
+// log_id that was already persisted is rejected with 409.
+func processSync(ctx context.Context, req events.APIGatewayV2HTTPRequest, settings config.Settings, message models.InternalMessage) events.APIGatewayV2HTTPResponse {
+	result, err := processor.Process(ctx, processor.NewClient(settings.AWSConfig), settings, message)
+	if errors.Is(err, processor.ErrEmptyText) {
+		return errorResponse(http.StatusBadRequest, "text is required")
+	}
+	if errors.Is(err, processor.ErrRedactionLeak) {
+		return errorResponse(http.StatusUnprocessableEntity, "redacted output failed verification")
+	}
+	if err != nil {
+		logging.Message(message).Error("synchronous processing failed", "error", err)
+		return errorResponse(http.StatusInternalServerError, "failed to process record")
+	}
+	if result.Duplicate {
+		return errorResponse(http.StatusConflict, "log_id already processed")
+	}
+	response := models.ProcessedResponse{
+		Status:       "processed",
+		TenantID:     message.TenantID,
+		LogID:        message.LogID,
+		TraceID:      message.TraceID,
+		Source:       message.Source,
+		ModifiedData: result.Redacted,
+		ProcessedAt:  result.ProcessedAt,
+	}
+	if req.QueryStringParameters["explain"] == "true" {
+		response.RedactionRules = redaction.Matching(message.Text, settings.RedactionRules)
+	}
+	switch {
+	case result.Skipped:
+		response.Status = "skipped"
+	case result.RolledUp:
+		response.Status = "rolled_up"
+	}
+	return jsonResponse(http.StatusOK, acceptedBody(req, settings, response))
+}
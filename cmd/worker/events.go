@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+
+	"memory-machine/internal/models"
+)
+
+// Event source and detail type for records the worker has persisted.
+const (
+	eventSource            = "robust-data-processor.worker"
+	eventDetailTypeProcess = "LogProcessed"
+)
+
+// putEventsAPI is the subset of the EventBridge client the worker uses.
+type putEventsAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// processedDetail is the detail of a LogProcessed event.
+type processedDetail struct {
+	TenantID string `json:"tenant_id"`
+	LogID    string `json:"log_id"`
+	TraceID  string `json:"trace_id,omitempty"`
+	Outcome  string `json:"outcome"`
+}
+
+// publishProcessed puts a LogProcessed event for message on bus.
+func publishProcessed(ctx context.Context, client putEventsAPI, bus string, message models.InternalMessage) error {
+	detail, err := json.Marshal(processedDetail{
+		TenantID: message.TenantID,
+		LogID:    message.LogID,
+		TraceID:  message.TraceID,
+		Outcome:  "persisted",
+	})
+	if err != nil {
+		return err
+	}
+	output, err := client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{{
+			EventBusName: &bus,
+			Source:       stringPtr(eventSource),
+			DetailType:   stringPtr(eventDetailTypeProcess),
+			Detail:       stringPtr(string(detail)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if output.FailedEntryCount > 0 && len(output.Entries) > 0 {
+		return fmt.Errorf("event rejected: %s: %s", aws.ToString(output.Entries[0].ErrorCode), aws.ToString(output.Entries[0].ErrorMessage))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// fakeEvents is a putEventsAPI that records every entry it is sent and
+// answers with err, or rejects each entry with rejectCode when set.
+type fakeEvents struct {
+	entries    []ebtypes.PutEventsRequestEntry
+	err        error
+	rejectCode string
+}
+
+func (f *fakeEvents) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.entries = append(f.entries, params.Entries...)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.rejectCode != "" {
+		return &eventbridge.PutEventsOutput{
+			FailedEntryCount: int32(len(params.Entries)),
+			Entries:          []ebtypes.PutEventsResultEntry{{ErrorCode: aws.String(f.rejectCode), ErrorMessage: aws.String("rejected")}},
+		}, nil
+	}
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+func TestPublishProcessed(t *testing.T) {
+	settings := testSettings()
+	settings.EventBusName = "tenant-events"
+	events := &fakeEvents{}
+	deps := dependencies{db: &fakeDB{}, events: events}
+	message := testMessage()
+	message.TraceID = "trace-1"
+
+	if err := processRecord(context.Background(), deps, settings, sqsRecord(t, message)); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	entries := events.entries
+	if len(entries) != 1 {
+		t.Fatalf("put %d events, want 1", len(entries))
+	}
+	entry := entries[0]
+	if aws.ToString(entry.EventBusName) != "tenant-events" || aws.ToString(entry.Source) != eventSource || aws.ToString(entry.DetailType) != "LogProcessed" {
+		t.Errorf("entry bus %q source %q detail type %q", aws.ToString(entry.EventBusName), aws.ToString(entry.Source), aws.ToString(entry.DetailType))
+	}
+	var detail map[string]string
+	if err := json.Unmarshal([]byte(aws.ToString(entry.Detail)), &detail); err != nil {
+		t.Fatalf("detail is not JSON: %v", err)
+	}
+	want := map[string]string{"tenant_id": "acme", "log_id": "log-1", "trace_id": "trace-1", "outcome": "persisted"}
+	if len(detail) != len(want) {
+		t.Errorf("detail = %v, want %v", detail, want)
+	}
+	for key, value := range want {
+		if detail[key] != value {
+			t.Errorf("detail %s = %q, want %q", key, detail[key], value)
+		}
+	}
+}
+
+func TestPublishProcessedSkipsDuplicates(t *testing.T) {
+	settings := testSettings()
+	settings.EventBusName = "tenant-events"
+	events := &fakeEvents{}
+	deps := dependencies{db: &fakeDB{putErr: &types.ConditionalCheckFailedException{}}, events: events}
+
+	if err := processRecord(context.Background(), deps, settings, sqsRecord(t, testMessage())); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	if len(events.entries) != 0 {
+		t.Errorf("put %d events for a duplicate, want none", len(events.entries))
+	}
+}
+
+func TestPublishProcessedFailureIsNotFatal(t *testing.T) {
+	tests := []struct {
+		name   string
+		events *fakeEvents
+	}{
+		{"call error", &fakeEvents{err: errors.New("AccessDenied")}},
+		{"rejected entry", &fakeEvents{rejectCode: "InternalFailure"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			settings := testSettings()
+			settings.EventBusName = "tenant-events"
+
+			deps := dependencies{db: &fakeDB{}, events: tt.events}
+			if err := processRecord(context.Background(), deps, settings, sqsRecord(t, testMessage())); err != nil {
+				t.Fatalf("processRecord: %v, want event failures to be best-effort", err)
+			}
+			if warnings := logsWithMessage(logs(), "processed event failed"); len(warnings) != 1 {
+				t.Errorf("logged %d event failures, want 1", len(warnings))
+			}
+		})
+	}
+}
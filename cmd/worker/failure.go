@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+
+	"memory-machine/internal/processor"
+)
+
+// failureReason categorizes why a record failed, for logs and metrics.
+type failureReason string
+
+const (
+	reasonParse           failureReason = "parse"
+	reasonValidation      failureReason = "validation"
+	reasonThrottle        failureReason = "throttle"
+	reasonContentRejected failureReason = "content-rejected"
+	reasonInternal        failureReason = "internal"
+)
+
+// recordError tags a processing error with its failure reason.
+type recordError struct {
+	reason failureReason
+	err    error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// fail wraps err with reason.
+func fail(reason failureReason, err error) error {
+	return &recordError{reason: reason, err: err}
+}
+
+// reasonOf returns the failure reason attached to err, defaulting to internal.
+func reasonOf(err error) failureReason {
+	var re *recordError
+	if errors.As(err, &re) {
+		return re.reason
+	}
+	return reasonInternal
+}
+
+// storageReason classifies a DynamoDB error as throttling or internal.
+func storageReason(err error) failureReason {
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		return reasonThrottle
+	}
+	return reasonInternal
+}
+
+// processReason classifies an error from processor.Process.
+func processReason(err error) failureReason {
+	switch {
+	case errors.Is(err, processor.ErrEmptyText):
+		return reasonValidation
+	case errors.Is(err, processor.ErrRedactionLeak):
+		return reasonContentRejected
+	case errors.Is(err, processor.ErrWritePacing):
+		return reasonThrottle
+	}
+	return storageReason(err)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/redaction"
+)
+
+// leakyRule matches phone-like numbers but replaces them with themselves.
+var leakyRule = redaction.Rule{
+	Name:    "leaky",
+	Pattern: regexp.MustCompile(`\d{3}-\d{4}`),
+	Replace: func(value string) string { return value },
+}
+
+func TestFailureReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(t *testing.T) events.SQSMessage
+		setup  func(*config.Settings, *fakeDB)
+		want   failureReason
+	}{
+		{
+			name:   "unparseable body",
+			record: func(t *testing.T) events.SQSMessage { return events.SQSMessage{MessageId: "m", Body: "{not json"} },
+			want:   reasonParse,
+		},
+		{
+			name: "empty text in fail mode",
+			record: func(t *testing.T) events.SQSMessage {
+				message := testMessage()
+				message.Text = ""
+				return sqsRecord(t, message)
+			},
+			setup: func(s *config.Settings, _ *fakeDB) { s.EmptyTextMode = config.EmptyTextFail },
+			want:  reasonValidation,
+		},
+		{
+			name: "redaction leak",
+			record: func(t *testing.T) events.SQSMessage {
+				message := testMessage()
+				message.Text = "call 555-1234"
+				return sqsRecord(t, message)
+			},
+			setup: func(s *config.Settings, _ *fakeDB) {
+				s.VerifyRedaction = true
+				s.RedactionRules = []redaction.Rule{leakyRule}
+			},
+			want: reasonContentRejected,
+		},
+		{
+			name:   "throttled write",
+			record: func(t *testing.T) events.SQSMessage { return sqsRecord(t, testMessage()) },
+			setup: func(_ *config.Settings, db *fakeDB) {
+				db.putErr = &types.ProvisionedThroughputExceededException{Message: stringPtr("slow down")}
+			},
+			want: reasonThrottle,
+		},
+		{
+			name:   "other storage error",
+			record: func(t *testing.T) events.SQSMessage { return sqsRecord(t, testMessage()) },
+			setup:  func(_ *config.Settings, db *fakeDB) { db.putErr = &types.ResourceNotFoundException{} },
+			want:   reasonInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			db := &fakeDB{}
+			if tt.setup != nil {
+				tt.setup(&settings, db)
+			}
+			err := processRecord(context.Background(), dependencies{db: db}, settings, tt.record(t))
+			if err == nil {
+				t.Fatal("processRecord succeeded, want a failure")
+			}
+			if got := reasonOf(err); got != tt.want {
+				t.Errorf("reason = %s, want %s (error %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestReasonOfUntaggedError(t *testing.T) {
+	if got := reasonOf(errors.New("simulated worker crash")); got != reasonInternal {
+		t.Errorf("reasonOf(untagged) = %s, want %s", got, reasonInternal)
+	}
+	wrapped := fail(reasonParse, errors.New("bad body"))
+	if got := reasonOf(errors.Join(errors.New("context"), wrapped)); got != reasonParse {
+		t.Errorf("reasonOf(joined) = %s, want %s", got, reasonParse)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/metrics"
+	"memory-machine/internal/models"
+)
+
+// fakeDynamoDB stands in for the DynamoDB endpoint behind AWS_ENDPOINT_URL.
+// It records PutItem calls and fails those whose log_id is in failures with
+// the mapped AWS error type.
+type fakeDynamoDB struct {
+	mu       sync.Mutex
+	puts     []map[string]any
+	failures map[string]string
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	var input map[string]any
+	_ = json.Unmarshal(raw, &input)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+	if r.Header.Get("X-Amz-Target") != "DynamoDB_20120810.PutItem" {
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	item, _ := input["Item"].(map[string]any)
+	logID, _ := item["log_id"].(map[string]any)["S"].(string)
+
+	f.mu.Lock()
+	f.puts = append(f.puts, input)
+	errorType, fail := f.failures[logID]
+	f.mu.Unlock()
+	if fail {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"__type": errorType, "message": "fake failure"})
+		return
+	}
+	_, _ = w.Write([]byte("{}"))
+}
+
+// putCount returns how many PutItem calls were received.
+func (f *fakeDynamoDB) putCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.puts)
+}
+
+// newFakeDynamoDB points the AWS SDK and the required settings at a fresh
+// fakeDynamoDB.
+func newFakeDynamoDB(t *testing.T) *fakeDynamoDB {
+	t.Helper()
+	fake := &fakeDynamoDB{failures: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("SQS_QUEUE_URL", server.URL+"/000000000000/ingest")
+	t.Setenv("DYNAMODB_TABLE_NAME", "tenant-logs")
+	return fake
+}
+
+// tenantRecord is an SQS record for a message from tenant with the tenant_id
+// attribute ingest sets.
+func tenantRecord(t *testing.T, tenant, logID string) events.SQSMessage {
+	t.Helper()
+	message := models.NewInternalMessage(tenant, logID, "json_upload", "hello")
+	record := sqsRecord(t, message)
+	record.MessageAttributes = map[string]events.SQSMessageAttribute{
+		models.AttributeTenantID: {DataType: "String", StringValue: stringPtr(tenant)},
+	}
+	return record
+}
+
+const conditionalCheckFailed = "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException"
+
+func TestRecordSpans(t *testing.T) {
+	fake := newFakeDynamoDB(t)
+	fake.failures["log-dup"] = conditionalCheckFailed
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		tenantRecord(t, "acme", "log-1"),
+		tenantRecord(t, "acme", "log-dup"),
+		{MessageId: "msg-bad", Body: "{not json"},
+	}}
+	if _, err := handleSQSEvent(context.Background(), event); err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != len(event.Records) {
+		t.Fatalf("exported %d spans, want one per record", len(spans))
+	}
+	want := []map[string]string{
+		{"messaging.message.id": "msg-log-1", "tenant_id": "acme", "source": "json_upload", "outcome": "persisted"},
+		{"messaging.message.id": "msg-log-dup", "tenant_id": "acme", "outcome": "duplicate"},
+		{"messaging.message.id": "msg-bad", "outcome": "failed", "failure.reason": string(reasonParse)},
+	}
+	for i, span := range spans {
+		if span.Name != "processRecord" {
+			t.Errorf("span %d name = %q, want processRecord", i, span.Name)
+		}
+		got := map[string]string{}
+		for _, attr := range span.Attributes {
+			got[string(attr.Key)] = attr.Value.Emit()
+		}
+		for key, value := range want[i] {
+			if got[key] != value {
+				t.Errorf("span %d %s = %q, want %q", i, key, got[key], value)
+			}
+		}
+	}
+}
+
+// countDependencies wraps newDependencies for the rest of the test and
+// returns how many times it was called.
+func countDependencies(t *testing.T) *int {
+	t.Helper()
+	calls := 0
+	previous := newDependencies
+	newDependencies = func(settings config.Settings) dependencies {
+		calls++
+		return previous(settings)
+	}
+	t.Cleanup(func() { newDependencies = previous })
+	return &calls
+}
+
+func TestEmptyBatchBuildsNoClients(t *testing.T) {
+	// With no table configured, loading config would fail the batch.
+	t.Setenv("DYNAMODB_TABLE_NAME", "")
+	calls := countDependencies(t)
+
+	response, err := handleSQSEvent(context.Background(), events.SQSEvent{})
+	if err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+	if len(response.BatchItemFailures) != 0 {
+		t.Errorf("failures = %v, want none", response.BatchItemFailures)
+	}
+	if *calls != 0 {
+		t.Errorf("built clients %d times for an empty batch", *calls)
+	}
+}
+
+func TestBatchBuildsClientsOnce(t *testing.T) {
+	fake := newFakeDynamoDB(t)
+	calls := countDependencies(t)
+
+	event := events.SQSEvent{Records: []events.SQSMessage{tenantRecord(t, "acme", "log-1"), tenantRecord(t, "acme", "log-2")}}
+	if _, err := handleSQSEvent(context.Background(), event); err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+	if *calls != 1 || fake.putCount() != 2 {
+		t.Errorf("built clients %d times for %d puts, want once for 2", *calls, fake.putCount())
+	}
+}
+
+func TestPartialBatchFailures(t *testing.T) {
+	fake := newFakeDynamoDB(t)
+	fake.failures["log-missing-table"] = "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException"
+	fake.failures["log-dup"] = conditionalCheckFailed
+
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		tenantRecord(t, "acme", "log-1"),
+		tenantRecord(t, "acme", "log-missing-table"),
+		{MessageId: "msg-bad", Body: "{not json"},
+		tenantRecord(t, "acme", "log-dup"),
+		tenantRecord(t, "acme", "log-2"),
+	}}
+	response, err := handleSQSEvent(context.Background(), event)
+	if err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+
+	var failed []string
+	for _, failure := range response.BatchItemFailures {
+		failed = append(failed, failure.ItemIdentifier)
+	}
+	want := []string{"msg-log-missing-table", "msg-bad"}
+	if len(failed) != len(want) || failed[0] != want[0] || failed[1] != want[1] {
+		t.Errorf("BatchItemFailures = %v, want %v", failed, want)
+	}
+	// Every record after a failure is still attempted; a duplicate is not a failure.
+	if got := fake.putCount(); got != 4 {
+		t.Errorf("puts = %d, want one per parseable record", got)
+	}
+}
+
+func TestConfigErrorFailsWholeBatch(t *testing.T) {
+	newFakeDynamoDB(t)
+	t.Setenv("DYNAMODB_TABLE_NAME", "")
+
+	if _, err := handleSQSEvent(context.Background(), events.SQSEvent{Records: []events.SQSMessage{tenantRecord(t, "acme", "log-1")}}); err == nil {
+		t.Error("handleSQSEvent succeeded without a table name")
+	}
+}
+
+// sentAt sets record's SentTimestamp system attribute.
+func sentAt(record events.SQSMessage, millis string) events.SQSMessage {
+	record.Attributes = map[string]string{"SentTimestamp": millis}
+	return record
+}
+
+func TestWarnOutOfOrder(t *testing.T) {
+	newFakeDynamoDB(t)
+	logs := captureLogs(t)
+
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		sentAt(tenantRecord(t, "acme", "log-1"), "2000"),
+		sentAt(tenantRecord(t, "globex", "log-2"), "1000"), // another tenant's clock
+		sentAt(tenantRecord(t, "acme", "log-3"), "1500"),   // older than log-1
+		sentAt(tenantRecord(t, "acme", "log-4"), "3000"),
+		sentAt(tenantRecord(t, "acme", "log-5"), "2500"), // older than log-4
+		tenantRecord(t, "acme", "log-6"),                 // no timestamp
+	}}
+	if _, err := handleSQSEvent(context.Background(), event); err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+
+	warnings := logsWithMessage(logs(), "out-of-order record")
+	want := []struct {
+		messageID string
+		previous  float64
+	}{{"msg-log-3", 2000}, {"msg-log-5", 3000}}
+	if len(warnings) != len(want) {
+		t.Fatalf("logged %d out-of-order warnings, want %d: %v", len(warnings), len(want), warnings)
+	}
+	for i, w := range want {
+		if warnings[i]["message_id"] != w.messageID || warnings[i]["previous_sent_timestamp"] != w.previous || warnings[i]["tenant_id"] != "acme" {
+			t.Errorf("warning %d = %v, want %s after %v", i, warnings[i], w.messageID, w.previous)
+		}
+	}
+}
+
+// captureMetrics discards metrics buffered by earlier tests, then sends EMF
+// records to a buffer for the rest of the test.
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	metrics.SetOutput(io.Discard)
+	metrics.Flush()
+	var buf bytes.Buffer
+	metrics.SetOutput(&buf)
+	t.Cleanup(func() { metrics.SetOutput(os.Stdout) })
+	return &buf
+}
+
+func TestMetricsFlushedAfterBatch(t *testing.T) {
+	fake := newFakeDynamoDB(t)
+	fake.failures["log-dup"] = conditionalCheckFailed
+	buf := captureMetrics(t)
+
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		tenantRecord(t, "acme", "log-1"),
+		tenantRecord(t, "acme", "log-2"),
+		tenantRecord(t, "acme", "log-dup"),
+	}}
+	if _, err := handleSQSEvent(context.Background(), event); err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+
+	// Counts are summed per series before the single flush on return.
+	got := map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("EMF line is not JSON: %v: %s", err, line)
+		}
+		for _, name := range []string{"RecordsProcessed", "DuplicatesDetected"} {
+			if value, ok := record[name]; ok && record["TenantID"] == "acme" {
+				got[name] = value
+			}
+		}
+	}
+	if got["RecordsProcessed"] != float64(2) || got["DuplicatesDetected"] != float64(1) {
+		t.Errorf("flushed %v, want RecordsProcessed 2 and DuplicatesDetected 1 for acme", got)
+	}
+}
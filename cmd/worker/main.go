@@ -5,86 +5,278 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
-	"regexp"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"memory-machine/internal/config"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/metrics"
 	"memory-machine/internal/models"
+	"memory-machine/internal/processor"
+	"memory-machine/internal/queuecrypt"
+	"memory-machine/internal/tracing"
 )
 
-var phonePattern = regexp.MustCompile(`\b\d{3}-\d{4}\b`)
-
 func main() {
+	slog.SetDefault(logging.New())
 	rand.Seed(time.Now().UnixNano())
 	lambda.Start(handleSQSEvent)
 }
 
-func handleSQSEvent(ctx context.Context, event events.SQSEvent) error {
+// newDependencies builds the clients a batch needs; tests can replace it.
+var newDependencies = func(settings config.Settings) dependencies {
+	deps := dependencies{
+		db:  processor.NewClient(settings.AWSConfig),
+		kms: kms.NewFromConfig(settings.AWSConfig),
+		payloads: s3.NewFromConfig(settings.AWSConfig, func(o *s3.Options) {
+			o.UsePathStyle = settings.AWSEndpointURL != ""
+		}),
+	}
+	if settings.OpenSearchEndpoint != "" {
+		deps.search = newSearchIndexer(&http.Client{Timeout: searchTimeout}, settings)
+	}
+	if settings.EventBusName != "" {
+		deps.events = eventbridge.NewFromConfig(settings.AWSConfig)
+	}
+	if settings.DRRegion != "" {
+		deps.drDB = dynamodb.NewFromConfig(settings.AWSConfig, func(o *dynamodb.Options) {
+			o.Region = settings.DRRegion
+		})
+	}
+	return deps
+}
+
+// handleSQSEvent processes every record and reports only the failed ones, so
+// Lambda deletes the rest instead of retrying the whole batch. Errors that
+// affect every record, such as bad configuration, still fail the batch.
+func handleSQSEvent(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	// Flush on return, and also if the deadline passes first, since the
+	// runtime may freeze the container before a timed-out handler returns.
+	defer metrics.Flush()
+	stopFlush := context.AfterFunc(ctx, metrics.Flush)
+	defer stopFlush()
+
+	var response events.SQSEventResponse
+	if len(event.Records) == 0 {
+		slog.Info("empty batch, nothing to process")
+		return response, nil
+	}
+
 	settings, err := config.Load(ctx)
 	if err != nil {
-		log.Printf("configuration error: %v", err)
-		return err
+		slog.Error("configuration error", "error", err)
+		return response, err
 	}
-	db := dynamodb.NewFromConfig(settings.AWSConfig)
+	deps := newDependencies(settings)
 
+	if err := tracing.Init(ctx, settings.OTLPEndpoint, "robust-data-processor-worker"); err != nil {
+		slog.Warn("tracing disabled", "error", err)
+	}
+	defer func() {
+		if err := tracing.Flush(ctx); err != nil {
+			slog.Warn("trace flush failed", "error", err)
+		}
+	}()
+	tracer := tracing.Tracer("memory-machine/worker")
+
+	lastSent := make(map[string]int64)
 	for _, record := range event.Records {
-		if err := processRecord(ctx, db, settings, record); err != nil {
-			return err
+		warnOutOfOrder(lastSent, record)
+		recordCtx, span := tracer.Start(xrayParent(ctx, record), "processRecord", trace.WithAttributes(attribute.String("messaging.message.id", record.MessageId)))
+		if err := processRecord(recordCtx, deps, settings, record); err != nil {
+			reason := reasonOf(err)
+			tenantID, _ := stringAttribute(record, models.AttributeTenantID)
+			slog.Error("record failed", "tenant_id", tenantID, "message_id", record.MessageId, "reason", string(reason), "error", err)
+			metrics.Count("RecordFailures", 1, map[string]string{"Reason": string(reason)})
+			span.SetAttributes(attribute.String("outcome", "failed"), attribute.String("failure.reason", string(reason)))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+			continue
 		}
+		span.End()
 	}
-	return nil
+	return response, nil
 }
 
-func processRecord(ctx context.Context, db *dynamodb.Client, settings config.Settings, record events.SQSMessage) error {
+// dependencies holds the service clients shared by every record in a batch.
+// drDB, search, and events are nil unless a DR table, OpenSearch endpoint, or
+// event bus is configured.
+type dependencies struct {
+	db       processor.DynamoDBAPI
+	kms      *kms.Client
+	drDB     processor.PutItemAPI
+	search   *searchIndexer
+	payloads objectGetAPI
+	events   putEventsAPI
+}
+
+// searchTimeout bounds each best-effort OpenSearch request.
+const searchTimeout = 5 * time.Second
+
+func processRecord(ctx context.Context, deps dependencies, settings config.Settings, record events.SQSMessage) error {
+	ctx = processor.WithStart(ctx, time.Now())
+	body := []byte(record.Body)
+	if encryption, _ := stringAttribute(record, queuecrypt.AttributeName); encryption == queuecrypt.AttributeValue {
+		plaintext, err := queuecrypt.Decrypt(ctx, deps.kms, body)
+		if err != nil {
+			return fail(reasonParse, fmt.Errorf("decrypt message body: %w", err))
+		}
+		body = plaintext
+	}
+
 	var message models.InternalMessage
-	if err := json.Unmarshal([]byte(record.Body), &message); err != nil {
-		return fmt.Errorf("invalid message body: %w", err)
+	if err := json.Unmarshal(body, &message); err != nil {
+		return fail(reasonParse, fmt.Errorf("invalid message body: %w", err))
+	}
+
+	// Attributes set by ingest take precedence; older messages only have the body.
+	if tenantID, ok := stringAttribute(record, models.AttributeTenantID); ok {
+		message.TenantID = tenantID
+	}
+	if source, ok := stringAttribute(record, models.AttributeSource); ok {
+		message.Source = source
+	}
+	if message.BodyRef != nil {
+		if err := loadBody(ctx, deps.payloads, &message); err != nil {
+			return fail(storageReason(err), fmt.Errorf("load offloaded body: %w", err))
+		}
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("tenant_id", message.TenantID), attribute.String("source", message.Source))
+	if message.Priority != "" {
+		span.SetAttributes(attribute.String("priority", message.Priority))
+	}
+	if header := record.Attributes[awsTraceHeader]; header != "" {
+		message.AmznTraceID = header
+	}
+	if message.AmznTraceID != "" {
+		span.SetAttributes(attribute.String("aws.xray.trace_header", message.AmznTraceID))
+	}
+
+	// Messages enqueued before trace IDs were introduced carry none.
+	if message.TraceID == "" {
+		message.TraceID = uuid.NewString()
+		logging.Message(message).Info("synthesized trace_id")
 	}
 
-	// Simulate crash with 5% probability for resilience testing.
-	if rand.Float64() < 0.05 {
+	// Simulate a crash for resilience testing; disabled unless CHAOS_CRASH_RATE is set.
+	if settings.ChaosCrashRate > 0 && rand.Float64() < settings.ChaosCrashRate {
 		return errors.New("simulated worker crash")
 	}
 
-	// Simulate heavy processing proportional to payload size.
-	sleepDuration := time.Duration(len(message.Text)) * 50 * time.Millisecond
-	time.Sleep(sleepDuration)
+	if settings.SimulateProcessing {
+		time.Sleep(simulatedProcessing(settings, message.Text))
+	}
 
-	redacted := phonePattern.ReplaceAllString(message.Text, "[REDACTED]")
-	processedAt := time.Now().UTC().Format(time.RFC3339)
+	result, err := processor.Process(ctx, deps.db, settings, message)
+	if err != nil {
+		return fail(processReason(err), err)
+	}
+	if result.Skipped {
+		span.SetAttributes(attribute.String("outcome", "skipped"))
+		return nil
+	}
+	if result.Duplicate {
+		span.SetAttributes(attribute.String("outcome", "duplicate"))
+		return nil
+	}
+	if result.RolledUp {
+		span.SetAttributes(attribute.String("outcome", "rolled_up"))
+		return nil
+	}
+	span.SetAttributes(attribute.String("outcome", "persisted"))
 
-	item := map[string]types.AttributeValue{
-		"tenant_id":     &types.AttributeValueMemberS{Value: message.TenantID},
-		"log_id":        &types.AttributeValueMemberS{Value: message.LogID},
-		"source":        &types.AttributeValueMemberS{Value: message.Source},
-		"original_text": &types.AttributeValueMemberS{Value: message.Text},
-		"modified_data": &types.AttributeValueMemberS{Value: redacted},
-		"processed_at":  &types.AttributeValueMemberS{Value: processedAt},
+	if deps.drDB != nil {
+		replicateToDR(ctx, deps.drDB, settings, message, result.Item)
+	}
+	if deps.search != nil {
+		err := deps.search.Index(ctx, searchDocument{
+			TenantID:    message.TenantID,
+			LogID:       message.LogID,
+			Source:      message.Source,
+			Text:        result.Redacted,
+			ProcessedAt: result.ProcessedAt,
+		})
+		if err != nil {
+			logging.Message(message).Warn("search indexing failed", "error", err)
+		}
+	}
+	if deps.events != nil {
+		if err := publishProcessed(ctx, deps.events, settings.EventBusName, message); err != nil {
+			logging.Message(message).Warn("processed event failed", "error", err)
+		}
 	}
+	return nil
+}
+
+// simulatedProcessing is the delay that simulates heavy processing of text:
+// proportional to its size, never less than the configured floor so empty
+// texts still take measurable time.
+func simulatedProcessing(settings config.Settings, text string) time.Duration {
+	return max(time.Duration(len(text))*50*time.Millisecond, settings.MinProcessing)
+}
 
-	_, err := db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName:           stringPtr(settings.DynamoDBTableName),
+// replicateToDR writes item to the DR table on a best-effort basis. Failures are
+// logged but never fail the record, since the primary write already succeeded.
+func replicateToDR(ctx context.Context, drDB processor.PutItemAPI, settings config.Settings, message models.InternalMessage, item map[string]types.AttributeValue) {
+	_, err := drDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           stringPtr(settings.DRTableName),
 		Item:                item,
-		ConditionExpression: stringPtr("attribute_not_exists(tenant_id) AND attribute_not_exists(log_id)"),
+		ConditionExpression: processor.InsertCondition(message),
 	})
 	if err != nil {
 		var cfe *types.ConditionalCheckFailedException
 		if errors.As(err, &cfe) {
-			log.Printf("duplicate detected tenant_id=%s log_id=%s", message.TenantID, message.LogID)
-			return nil
+			return
 		}
-		return fmt.Errorf("dynamodb put error: %w", err)
+		logging.Message(message).Warn("dr replication failed", "region", settings.DRRegion, "error", err)
 	}
+}
 
-	log.Printf("persisted tenant_id=%s log_id=%s processed_at=%s", message.TenantID, message.LogID, processedAt)
-	return nil
+// warnOutOfOrder logs a record whose SentTimestamp is older than the last one
+// seen for the same tenant in this batch. Records without a tenant_id
+// attribute or timestamp are ignored.
+func warnOutOfOrder(lastSent map[string]int64, record events.SQSMessage) {
+	tenantID, ok := stringAttribute(record, models.AttributeTenantID)
+	if !ok {
+		return
+	}
+	sent, err := strconv.ParseInt(record.Attributes["SentTimestamp"], 10, 64)
+	if err != nil {
+		return
+	}
+	if previous, seen := lastSent[tenantID]; seen && sent < previous {
+		slog.Warn("out-of-order record", "tenant_id", tenantID, "message_id", record.MessageId, "sent_timestamp", sent, "previous_sent_timestamp", previous)
+		return
+	}
+	lastSent[tenantID] = sent
+}
+
+// stringAttribute returns a non-empty string message attribute from record.
+func stringAttribute(record events.SQSMessage, name string) (string, bool) {
+	attr, ok := record.MessageAttributes[name]
+	if !ok || attr.StringValue == nil || *attr.StringValue == "" {
+		return "", false
+	}
+	return *attr.StringValue, true
 }
 
 func stringPtr(s string) *string {
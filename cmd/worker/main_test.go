@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/models"
+)
+
+// fakeDB is a processor.DynamoDBAPI that records every call and answers
+// PutItem with putErr.
+type fakeDB struct {
+	puts         []*dynamodb.PutItemInput
+	updates      []*dynamodb.UpdateItemInput
+	transactions []*dynamodb.TransactWriteItemsInput
+	putErr       error
+}
+
+func (f *fakeDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts = append(f.puts, params)
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.updates = append(f.updates, params)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.transactions = append(f.transactions, params)
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// testSettings returns the settings Load produces with no optional variables set.
+func testSettings() config.Settings {
+	return config.Settings{
+		DynamoDBTableName: "tenant-logs",
+		DayBucketLocation: time.UTC,
+		EmptyTextMode:     config.EmptyTextStore,
+		PutMaxAttempts:    1,
+	}
+}
+
+// sqsRecord wraps message as the SQS record ingest would have sent.
+func sqsRecord(t *testing.T, message models.InternalMessage) events.SQSMessage {
+	t.Helper()
+	body, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.SQSMessage{MessageId: "msg-" + message.LogID, Body: string(body)}
+}
+
+// captureLogs sends the default logger to a buffer for the rest of the test
+// and returns a function listing the records logged so far.
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return func() []map[string]any {
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) == nil {
+				records = append(records, record)
+			}
+		}
+		return records
+	}
+}
+
+// logsWithMessage returns the records whose msg is msg.
+func logsWithMessage(records []map[string]any, msg string) []map[string]any {
+	var matched []map[string]any
+	for _, record := range records {
+		if record["msg"] == msg {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+func testMessage() models.InternalMessage {
+	return models.NewInternalMessage("acme", "log-1", "json_upload", "hello")
+}
+
+func TestReplicateToDR(t *testing.T) {
+	tests := []struct {
+		name       string
+		primaryErr error
+		drErr      error
+		wantErr    bool
+		wantDRPuts int
+	}{
+		{"both succeed", nil, nil, false, 1},
+		{"dr failure does not fail the record", nil, errors.New("region unavailable"), false, 1},
+		{"primary failure skips dr", errors.New("table unavailable"), nil, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &fakeDB{putErr: tt.primaryErr}
+			dr := &fakeDB{putErr: tt.drErr}
+			settings := testSettings()
+			settings.DRRegion, settings.DRTableName = "us-west-2", "tenant-logs-dr"
+
+			err := processRecord(context.Background(), dependencies{db: primary, drDB: dr}, settings, sqsRecord(t, testMessage()))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processRecord error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(primary.puts) != 1 || *primary.puts[0].TableName != "tenant-logs" {
+				t.Fatalf("primary puts = %d, want 1 to tenant-logs", len(primary.puts))
+			}
+			if len(dr.puts) != tt.wantDRPuts {
+				t.Fatalf("dr puts = %d, want %d", len(dr.puts), tt.wantDRPuts)
+			}
+			if tt.wantDRPuts > 0 {
+				if got := *dr.puts[0].TableName; got != "tenant-logs-dr" {
+					t.Errorf("dr table = %q, want tenant-logs-dr", got)
+				}
+				if dr.puts[0].ConditionExpression == nil {
+					t.Error("dr put has no insert condition")
+				}
+				if len(dr.puts[0].Item) != len(primary.puts[0].Item) {
+					t.Errorf("dr item has %d attributes, primary has %d", len(dr.puts[0].Item), len(primary.puts[0].Item))
+				}
+			}
+		})
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceID     string
+		synthesized bool
+	}{
+		{"present", "trace-from-ingest", false},
+		{"absent", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			message := testMessage()
+			message.TraceID = tt.traceID
+
+			if err := processRecord(context.Background(), dependencies{db: &fakeDB{}}, testSettings(), sqsRecord(t, message)); err != nil {
+				t.Fatalf("processRecord: %v", err)
+			}
+
+			records := logs()
+			synthesized := logsWithMessage(records, "synthesized trace_id")
+			if got := len(synthesized) == 1; got != tt.synthesized {
+				t.Fatalf("synthesized trace_id logged %d times, want synthesized=%v", len(synthesized), tt.synthesized)
+			}
+			persisted := logsWithMessage(records, "persisted")
+			if len(persisted) != 1 {
+				t.Fatalf("persisted logged %d times, want 1", len(persisted))
+			}
+			traceID, _ := persisted[0]["trace_id"].(string)
+			switch {
+			case traceID == "":
+				t.Error("persisted log has an empty trace_id")
+			case !tt.synthesized && traceID != tt.traceID:
+				t.Errorf("trace_id = %q, want %q", traceID, tt.traceID)
+			case tt.synthesized && traceID != synthesized[0]["trace_id"]:
+				t.Errorf("persisted trace_id %q differs from synthesized %v", traceID, synthesized[0]["trace_id"])
+			}
+		})
+	}
+}
+
+// itemString returns a string attribute of a written item.
+func itemString(item map[string]types.AttributeValue, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+func TestMessageAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]events.SQSMessageAttribute
+		wantTenant string
+		wantSource string
+	}{
+		{"absent falls back to the body", nil, "acme", "json_upload"},
+		{"present overrides the body", map[string]events.SQSMessageAttribute{
+			models.AttributeTenantID: {DataType: "String", StringValue: stringPtr("globex")},
+			models.AttributeSource:   {DataType: "String", StringValue: stringPtr("text_upload")},
+		}, "globex", "text_upload"},
+		{"empty values are ignored", map[string]events.SQSMessageAttribute{
+			models.AttributeTenantID: {DataType: "String", StringValue: stringPtr("")},
+		}, "acme", "json_upload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			record := sqsRecord(t, testMessage())
+			record.MessageAttributes = tt.attributes
+
+			if err := processRecord(context.Background(), dependencies{db: db}, testSettings(), record); err != nil {
+				t.Fatalf("processRecord: %v", err)
+			}
+			item := db.puts[0].Item
+			if got := itemString(item, "tenant_id"); got != tt.wantTenant {
+				t.Errorf("tenant_id = %q, want %q", got, tt.wantTenant)
+			}
+			if got := itemString(item, "source"); got != tt.wantSource {
+				t.Errorf("source = %q, want %q", got, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestSimulatedProcessing(t *testing.T) {
+	tests := []struct {
+		name  string
+		floor time.Duration
+		text  string
+		want  time.Duration
+	}{
+		{"empty without floor", 0, "", 0},
+		{"empty uses floor", 100 * time.Millisecond, "", 100 * time.Millisecond},
+		{"small text below floor", 100 * time.Millisecond, "a", 100 * time.Millisecond},
+		{"text above floor", 100 * time.Millisecond, "abc", 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.MinProcessing = tt.floor
+			if got := simulatedProcessing(settings, tt.text); got != tt.want {
+				t.Errorf("simulatedProcessing(%q) = %s, want %s", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatedProcessingSleepsForEmptyText(t *testing.T) {
+	settings := testSettings()
+	settings.SimulateProcessing = true
+	settings.MinProcessing = 20 * time.Millisecond
+	message := testMessage()
+	message.Text = ""
+
+	start := time.Now()
+	if err := processRecord(context.Background(), dependencies{db: &fakeDB{}}, settings, sqsRecord(t, message)); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < settings.MinProcessing {
+		t.Errorf("processRecord took %s, want at least the %s floor", elapsed, settings.MinProcessing)
+	}
+}
+
+func TestSimulatedProcessingOffByDefault(t *testing.T) {
+	settings := testSettings()
+	settings.MinProcessing = time.Hour
+	message := testMessage()
+	message.Text = strings.Repeat("a", 2048)
+
+	start := time.Now()
+	if err := processRecord(context.Background(), dependencies{db: &fakeDB{}}, settings, sqsRecord(t, message)); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("processRecord took %s without SIMULATE_PROCESSING, want no delay", elapsed)
+	}
+}
+
+func TestAmznTraceIDLogged(t *testing.T) {
+	logs := captureLogs(t)
+	message := testMessage()
+	message.TraceID = "trace-from-ingest"
+	message.AmznTraceID = "Root=1-5759e988-bd862e3fe1be46a994272793"
+
+	if err := processRecord(context.Background(), dependencies{db: &fakeDB{}}, testSettings(), sqsRecord(t, message)); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	persisted := logsWithMessage(logs(), "persisted")
+	if len(persisted) != 1 {
+		t.Fatalf("persisted logged %d times, want 1", len(persisted))
+	}
+	if got := persisted[0]["amzn_trace_id"]; got != message.AmznTraceID {
+		t.Errorf("amzn_trace_id = %v, want %q", got, message.AmznTraceID)
+	}
+	if persisted[0]["trace_id"] != message.TraceID {
+		t.Errorf("trace_id = %v, want %q", persisted[0]["trace_id"], message.TraceID)
+	}
+}
+
+func TestChaosCrashRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		wantCrash bool
+	}{
+		{"disabled by default", 0, false},
+		{"always", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.ChaosCrashRate = tt.rate
+			for i := 0; i < 20; i++ {
+				db := &fakeDB{}
+				err := processRecord(context.Background(), dependencies{db: db}, settings, sqsRecord(t, testMessage()))
+				if crashed := err != nil; crashed != tt.wantCrash {
+					t.Fatalf("processRecord error = %v, want crash %v", err, tt.wantCrash)
+				}
+				if tt.wantCrash && len(db.puts) != 0 {
+					t.Fatal("crashed record was written")
+				}
+			}
+		})
+	}
+}
+
+func TestRecordLogsCarryMessageFields(t *testing.T) {
+	tests := []struct {
+		name string
+		db   *fakeDB
+		msg  string
+	}{
+		{"persisted", &fakeDB{}, "persisted"},
+		{"duplicate", &fakeDB{putErr: &types.ConditionalCheckFailedException{}}, "duplicate detected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			if err := processRecord(context.Background(), dependencies{db: tt.db}, testSettings(), sqsRecord(t, testMessage())); err != nil {
+				t.Fatalf("processRecord: %v", err)
+			}
+			lines := logsWithMessage(logs(), tt.msg)
+			if len(lines) != 1 {
+				t.Fatalf("%q logged %d times, want 1", tt.msg, len(lines))
+			}
+			want := map[string]any{"level": "INFO", "tenant_id": "acme", "log_id": "log-1", "source": "json_upload"}
+			for key, value := range want {
+				if lines[0][key] != value {
+					t.Errorf("%s = %v, want %v", key, lines[0][key], value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"memory-machine/internal/models"
+)
+
+// objectGetAPI is the subset of the S3 client used to load offloaded texts.
+type objectGetAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// loadBody fills message.Text from its BodyRef. Objects are left in place so
+// redelivered messages can still be processed; the bucket's lifecycle rules
+// expire them.
+func loadBody(ctx context.Context, client objectGetAPI, message *models.InternalMessage) error {
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &message.BodyRef.Bucket, Key: &message.BodyRef.Key})
+	if err != nil {
+		return fmt.Errorf("get s3://%s/%s: %w", message.BodyRef.Bucket, message.BodyRef.Key, err)
+	}
+	defer output.Body.Close()
+	text, err := io.ReadAll(output.Body)
+	if err != nil {
+		return fmt.Errorf("read s3://%s/%s: %w", message.BodyRef.Bucket, message.BodyRef.Key, err)
+	}
+	message.Text = string(text)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"memory-machine/internal/models"
+)
+
+// fakeObjects is an objectGetAPI serving objects by bucket/key.
+type fakeObjects struct {
+	objects map[string]string
+}
+
+func (f fakeObjects) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	text, ok := f.objects[aws.ToString(params.Bucket)+"/"+aws.ToString(params.Key)]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(text))}, nil
+}
+
+// offloadedMessage is testMessage with its text replaced by a reference.
+func offloadedMessage() models.InternalMessage {
+	message := testMessage()
+	message.Text = ""
+	message.BodyRef = &models.BodyRef{Bucket: "acme-ingest-payloads", Key: "payloads/acme/log-1/trace-1"}
+	return message
+}
+
+func TestProcessRecordLoadsOffloadedBody(t *testing.T) {
+	db := &fakeDB{}
+	deps := dependencies{db: db, payloads: fakeObjects{objects: map[string]string{
+		"acme-ingest-payloads/payloads/acme/log-1/trace-1": "call 555-1234",
+	}}}
+	if err := processRecord(context.Background(), deps, testSettings(), sqsRecord(t, offloadedMessage())); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	if len(db.puts) != 1 {
+		t.Fatalf("puts = %d, want 1", len(db.puts))
+	}
+	if got := itemString(db.puts[0].Item, "original_text"); got != "call 555-1234" {
+		t.Errorf("original_text = %q, want the offloaded text", got)
+	}
+}
+
+func TestProcessRecordMissingOffloadedBody(t *testing.T) {
+	db := &fakeDB{}
+	deps := dependencies{db: db, payloads: fakeObjects{}}
+	err := processRecord(context.Background(), deps, testSettings(), sqsRecord(t, offloadedMessage()))
+	if err == nil || !strings.Contains(err.Error(), "s3://acme-ingest-payloads/payloads/acme/log-1/trace-1") {
+		t.Fatalf("processRecord = %v, want an error naming the object", err)
+	}
+	if reasonOf(err) != reasonInternal {
+		t.Errorf("reason = %s, want %s", reasonOf(err), reasonInternal)
+	}
+	if len(db.puts) != 0 {
+		t.Error("record persisted without its text")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	"memory-machine/internal/config"
+)
+
+// httpDoer is the subset of *http.Client used to reach OpenSearch.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// searchDocument is the body indexed for each persisted record.
+type searchDocument struct {
+	TenantID    string `json:"tenant_id"`
+	LogID       string `json:"log_id"`
+	Source      string `json:"source"`
+	Text        string `json:"text"`
+	ProcessedAt string `json:"processed_at"`
+}
+
+// searchIndexer writes redacted records to an OpenSearch index, signing each
+// request with SigV4 for Amazon OpenSearch Service.
+type searchIndexer struct {
+	client   httpDoer
+	endpoint string
+	index    string
+	awsCfg   aws.Config
+	signer   *v4.Signer
+}
+
+func newSearchIndexer(client httpDoer, settings config.Settings) *searchIndexer {
+	return &searchIndexer{
+		client:   client,
+		endpoint: strings.TrimRight(settings.OpenSearchEndpoint, "/"),
+		index:    settings.OpenSearchIndex,
+		awsCfg:   settings.AWSConfig,
+		signer:   v4.NewSigner(),
+	}
+}
+
+// Index upserts doc under the document ID "tenant_id|log_id", so redelivered
+// records overwrite rather than duplicate their search entry.
+func (s *searchIndexer) Index(ctx context.Context, doc searchDocument) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("marshal search document: %w", err)
+	}
+	docID := url.PathEscape(doc.TenantID + "|" + doc.LogID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/%s/_doc/%s", s.endpoint, url.PathEscape(s.index), docID), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build index request: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+
+	creds, err := s.awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieve credentials: %w", err)
+	}
+	payloadHash := sha256.Sum256(body)
+	if err := s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "es", s.awsCfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("sign index request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("index request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("index request: status %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/redaction"
+)
+
+// fakeDoer is an httpDoer that records each request and its body, answering
+// with status, or with err when set.
+type fakeDoer struct {
+	requests []*http.Request
+	bodies   []string
+	status   int
+	err      error
+}
+
+func (f *fakeDoer) Do(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	f.requests = append(f.requests, req)
+	f.bodies = append(f.bodies, string(body))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &http.Response{StatusCode: f.status, Body: io.NopCloser(strings.NewReader(`{"result":"created"}`))}, nil
+}
+
+// searchSettings configures an OpenSearch endpoint with static credentials.
+func searchSettings() config.Settings {
+	settings := testSettings()
+	settings.RedactionRules = redaction.DefaultRules
+	settings.OpenSearchEndpoint = "https://search.example.com/"
+	settings.OpenSearchIndex = "tenant-logs"
+	settings.AWSConfig = aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	}
+	return settings
+}
+
+func TestSearchIndexRequest(t *testing.T) {
+	doer := &fakeDoer{status: http.StatusCreated}
+	settings := searchSettings()
+	message := testMessage()
+	message.Text = "call 555-1234"
+
+	deps := dependencies{db: &fakeDB{}, search: newSearchIndexer(doer, settings)}
+	if err := processRecord(context.Background(), deps, settings, sqsRecord(t, message)); err != nil {
+		t.Fatalf("processRecord: %v", err)
+	}
+	if len(doer.requests) != 1 {
+		t.Fatalf("index requests = %d, want 1", len(doer.requests))
+	}
+
+	req := doer.requests[0]
+	if req.Method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", req.Method)
+	}
+	if got, want := req.URL.String(), "https://search.example.com/tenant-logs/_doc/acme%7Clog-1"; got != want {
+		t.Errorf("url = %s, want %s", got, want)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "/es/aws4_request") {
+		t.Errorf("Authorization = %q, want a SigV4 signature for es", req.Header.Get("Authorization"))
+	}
+
+	var doc map[string]string
+	if err := json.Unmarshal([]byte(doer.bodies[0]), &doc); err != nil {
+		t.Fatalf("index body is not JSON: %v", err)
+	}
+	want := map[string]string{"tenant_id": "acme", "log_id": "log-1", "source": "json_upload", "text": "call [REDACTED]"}
+	for key, value := range want {
+		if doc[key] != value {
+			t.Errorf("%s = %q, want %q", key, doc[key], value)
+		}
+	}
+	if doc["processed_at"] == "" {
+		t.Error("processed_at is empty")
+	}
+}
+
+func TestSearchIndexFailureIsNotFatal(t *testing.T) {
+	tests := []struct {
+		name  string
+		doer  *fakeDoer
+		error string
+	}{
+		{"error status", &fakeDoer{status: http.StatusInternalServerError}, "status 500"},
+		{"transport error", &fakeDoer{err: errors.New("connection refused")}, "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			settings := searchSettings()
+			db := &fakeDB{}
+
+			deps := dependencies{db: db, search: newSearchIndexer(tt.doer, settings)}
+			if err := processRecord(context.Background(), deps, settings, sqsRecord(t, testMessage())); err != nil {
+				t.Fatalf("processRecord: %v, want indexing failures to be best-effort", err)
+			}
+			if len(db.puts) != 1 {
+				t.Errorf("puts = %d, want the record persisted", len(db.puts))
+			}
+			warnings := logsWithMessage(logs(), "search indexing failed")
+			if len(warnings) != 1 || !strings.Contains(warnings[0]["error"].(string), tt.error) {
+				t.Errorf("warnings = %v, want one mentioning %q", warnings, tt.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// awsTraceHeader is the SQS system attribute carrying the sender's X-Ray header.
+const awsTraceHeader = "AWSTraceHeader"
+
+// xrayParent returns ctx carrying the record's X-Ray trace as a remote parent,
+// so worker spans join the trace started at ingest. ctx is returned unchanged
+// when the record has no usable header.
+func xrayParent(ctx context.Context, record events.SQSMessage) context.Context {
+	spanContext, ok := parseXRayHeader(record.Attributes[awsTraceHeader])
+	if !ok {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, spanContext)
+}
+
+// parseXRayHeader converts "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+// into a span context. X-Ray trace IDs are the W3C trace ID split after the
+// first eight hex digits.
+func parseXRayHeader(header string) (trace.SpanContext, bool) {
+	var config trace.SpanContextConfig
+	for _, part := range strings.Split(header, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "Root":
+			fields := strings.Split(value, "-")
+			if len(fields) != 3 || fields[0] != "1" {
+				return trace.SpanContext{}, false
+			}
+			traceID, err := trace.TraceIDFromHex(fields[1] + fields[2])
+			if err != nil {
+				return trace.SpanContext{}, false
+			}
+			config.TraceID = traceID
+		case "Parent":
+			spanID, err := trace.SpanIDFromHex(value)
+			if err != nil {
+				return trace.SpanContext{}, false
+			}
+			config.SpanID = spanID
+		case "Sampled":
+			if value == "1" {
+				config.TraceFlags = trace.FlagsSampled
+			}
+		}
+	}
+	config.Remote = true
+	spanContext := trace.NewSpanContext(config)
+	return spanContext, spanContext.IsValid()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const xrayHeader = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+
+func TestParseXRayHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{"sampled", xrayHeader, true, true},
+		{"not sampled", "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0", true, false},
+		{"spaces around parts", "Root=1-5759e988-bd862e3fe1be46a994272793; Parent=53995c3f42cd8ad8", true, false},
+		{"empty", "", false, false},
+		{"no parent", "Root=1-5759e988-bd862e3fe1be46a994272793", false, false},
+		{"wrong root version", "Root=2-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8", false, false},
+		{"bad root hex", "Root=1-5759e988-zz862e3fe1be46a994272793;Parent=53995c3f42cd8ad8", false, false},
+		{"bad parent hex", "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=xyz", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spanContext, ok := parseXRayHeader(tt.header)
+			if ok != tt.wantOK {
+				t.Fatalf("parseXRayHeader ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got := spanContext.TraceID().String(); got != "5759e988bd862e3fe1be46a994272793" {
+				t.Errorf("trace ID = %s, want the Root without its version and dash", got)
+			}
+			if got := spanContext.SpanID().String(); got != "53995c3f42cd8ad8" {
+				t.Errorf("span ID = %s, want the Parent", got)
+			}
+			if spanContext.IsSampled() != tt.wantSampled {
+				t.Errorf("sampled = %v, want %v", spanContext.IsSampled(), tt.wantSampled)
+			}
+			if !spanContext.IsRemote() {
+				t.Error("span context is not remote")
+			}
+		})
+	}
+}
+
+func TestRecordSpanJoinsXRayTrace(t *testing.T) {
+	newFakeDynamoDB(t)
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	traced := tenantRecord(t, "acme", "log-1")
+	traced.Attributes = map[string]string{awsTraceHeader: xrayHeader}
+	untraced := tenantRecord(t, "acme", "log-2")
+	if _, err := handleSQSEvent(context.Background(), events.SQSEvent{Records: []events.SQSMessage{traced, untraced}}); err != nil {
+		t.Fatalf("handleSQSEvent: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "5759e988bd862e3fe1be46a994272793" {
+		t.Errorf("traced record span trace ID = %s, want the X-Ray root", got)
+	}
+	if got := spans[0].Parent.SpanID().String(); got != "53995c3f42cd8ad8" {
+		t.Errorf("traced record span parent = %s, want the X-Ray parent", got)
+	}
+	attributes := map[string]string{}
+	for _, attr := range spans[0].Attributes {
+		attributes[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attributes["aws.xray.trace_header"] != xrayHeader {
+		t.Errorf("aws.xray.trace_header = %q, want %q", attributes["aws.xray.trace_header"], xrayHeader)
+	}
+	if spans[1].Parent.IsValid() {
+		t.Errorf("untraced record span has parent %s, want a new root", spans[1].Parent.SpanID())
+	}
+}
@@ -80,10 +80,15 @@ resource "aws_iam_role" "worker_role" {
 
 data "aws_iam_policy_document" "ingest_policy" {
   statement {
-    actions   = ["sqs:SendMessage"]
+    actions   = ["sqs:SendMessage", "sqs:GetQueueAttributes"]
     resources = [aws_sqs_queue.log_ingest_queue.arn]
   }
 
+  statement {
+    actions   = ["dynamodb:PutItem"]
+    resources = [aws_dynamodb_table.tenant_logs.arn]
+  }
+
   statement {
     actions = [
       "logs:CreateLogGroup",
@@ -209,5 +214,7 @@ resource "aws_lambda_event_source_mapping" "worker_sqs_mapping" {
   event_source_arn = aws_sqs_queue.log_ingest_queue.arn
   function_name    = aws_lambda_function.worker.arn
   batch_size       = 5
+
+  function_response_types = ["ReportBatchItemFailures"]
 }
 
@@ -0,0 +1,34 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrUnknownKey is returned for keys that do not belong to any tenant.
+var ErrUnknownKey = errors.New("unknown API key")
+
+// KeyStore resolves an API key to the tenant it was issued to. Implementations
+// may be backed by configuration or an external secret store.
+type KeyStore interface {
+	TenantFor(ctx context.Context, key string) (string, error)
+}
+
+// StaticStore is a KeyStore over a fixed map of API key to tenant ID.
+type StaticStore map[string]string
+
+// TenantFor compares key against every entry in constant time so lookups do
+// not leak how much of a key matched.
+func (s StaticStore) TenantFor(_ context.Context, key string) (string, error) {
+	var tenantID string
+	for candidate, tenant := range s {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			tenantID = tenant
+		}
+	}
+	if key == "" || tenantID == "" {
+		return "", ErrUnknownKey
+	}
+	return tenantID, nil
+}
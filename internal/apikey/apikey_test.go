@@ -0,0 +1,48 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStaticStoreTenantFor(t *testing.T) {
+	store := StaticStore{"k_live_acme": "acme", "k_live_beta": "beta"}
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr error
+	}{
+		{"known key", "k_live_acme", "acme", nil},
+		{"another tenant's key", "k_live_beta", "beta", nil},
+		{"unknown key", "k_live_nope", "", ErrUnknownKey},
+		{"prefix of a key", "k_live_ac", "", ErrUnknownKey},
+		{"key with suffix", "k_live_acme2", "", ErrUnknownKey},
+		{"different case", "K_LIVE_ACME", "", ErrUnknownKey},
+		{"empty key", "", "", ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.TenantFor(context.Background(), tt.key)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("TenantFor(%q) = %q, %v; want %q, %v", tt.key, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStaticStoreEmptyKeyEntry(t *testing.T) {
+	// A misconfigured empty key must not let keyless requests through.
+	store := StaticStore{"": "acme"}
+	if got, err := store.TenantFor(context.Background(), ""); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("TenantFor(\"\") = %q, %v; want ErrUnknownKey", got, err)
+	}
+}
+
+func TestStaticStoreEmptyTenant(t *testing.T) {
+	store := StaticStore{"k_live_orphan": ""}
+	if got, err := store.TenantFor(context.Background(), "k_live_orphan"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("TenantFor = %q, %v; want ErrUnknownKey for a key with no tenant", got, err)
+	}
+}
@@ -2,11 +2,22 @@ package config
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the zone database so DAY_BUCKET_TIMEZONE resolves on minimal Lambda runtimes.
+	_ "time/tzdata"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+
+	"memory-machine/internal/redaction"
+	"memory-machine/internal/transform"
 )
 
 // Settings holds resolved configuration and shared AWS config.
@@ -14,6 +25,90 @@ type Settings struct {
 	AWSConfig         aws.Config
 	SQSQueueURL       string
 	DynamoDBTableName string
+	RejectBinaryText  bool
+	DayBucketLocation *time.Location
+	EncryptQueueBody  bool
+	KMSKeyID          string
+	TenantKMSKeys     map[string]string
+	DRRegion          string
+	DRTableName       string
+	Maintenance       *TimeWindow
+	Transform         *transform.Transform
+	EnrichProvenance  bool
+	ResponseEnvelope  bool
+	RedactionAudit    string
+	RedactionHashKey  []byte
+	// TenantSources maps a tenant to the only source it is expected to use.
+	TenantSources      map[string]string
+	TenantSourceStrict bool
+	MinProcessing      time.Duration
+	OTLPEndpoint       string
+	// IdempotencyTableName enables Idempotency-Key dedup at ingest when set.
+	IdempotencyTableName   string
+	IdempotencyTTL         time.Duration
+	RedactionDebug         bool
+	StrictJSON             bool
+	MaxDecodedBodyBytes    int64
+	EnrichTextStats        bool
+	MaxFormBytes           int
+	VerifyRedaction        bool
+	RequireContentLength   bool
+	DetectLanguage         bool
+	OpenSearchEndpoint     string
+	OpenSearchIndex        string
+	TenantContentTypes     map[string][]string
+	RedactionRules         []redaction.Rule
+	EnrichRecordID         bool
+	LowPriorityDelay       int32
+	MaxBatchRecords        int
+	FlagRedaction          bool
+	RecordTTLDays          int
+	ChaosCrashRate         float64
+	APIKeys                map[string]string
+	TenantWriteRates       map[string]float64
+	EnqueueRetryAfter      int
+	MaxTextBytes           int
+	PayloadBucket          string
+	EmptyTextMode          string
+	EventBusName           string
+	BackpressureDepth      int
+	BackpressureRetryAfter int
+	AWSEndpointURL         string
+	ItemEnvelopes          bool
+	PutMaxAttempts         int
+	PutRetryBase           time.Duration
+	SimulateProcessing     bool
+	SyncMaxTextBytes       int
+	MaxHeaderCount         int
+	FIFOQueue              bool
+	StoreLatency           bool
+	RegisteredTenants      map[string]bool
+	RollupTableName        string
+	RollupOnlyTenants      map[string]bool
+}
+
+// REDACTION_AUDIT modes.
+const (
+	RedactionAuditCounts = "counts"
+	RedactionAuditHashes = "hashes"
+)
+
+// EMPTY_TEXT_MODE values.
+const (
+	EmptyTextStore = "store"
+	EmptyTextSkip  = "skip"
+	EmptyTextFail  = "fail"
+)
+
+// TimeWindow is a half-open [Start, End) time range.
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains reports whether t falls inside the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
 }
 
 // Load reads environment variables and AWS configuration.
@@ -23,6 +118,17 @@ func Load(ctx context.Context) (Settings, error) {
 		return Settings{}, fmt.Errorf("load AWS config: %w", err)
 	}
 
+	// The SDK reads AWS_ENDPOINT_URL on its own; validating it here turns a typo
+	// into a clear startup error instead of connection failures per call.
+	awsEndpointURL := os.Getenv("AWS_ENDPOINT_URL")
+	if awsEndpointURL != "" {
+		parsed, err := url.Parse(awsEndpointURL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return Settings{}, fmt.Errorf("invalid AWS_ENDPOINT_URL %q: must be an absolute URL", awsEndpointURL)
+		}
+		awsCfg.BaseEndpoint = aws.String(awsEndpointURL)
+	}
+
 	sqsURL := os.Getenv("SQS_QUEUE_URL")
 	if sqsURL == "" {
 		return Settings{}, fmt.Errorf("missing SQS_QUEUE_URL")
@@ -33,10 +139,606 @@ func Load(ctx context.Context) (Settings, error) {
 		return Settings{}, fmt.Errorf("missing DYNAMODB_TABLE_NAME")
 	}
 
+	rejectBinary, err := boolEnv("REJECT_BINARY_TEXT")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	dayBucketLocation := time.UTC
+	if tz := os.Getenv("DAY_BUCKET_TIMEZONE"); tz != "" {
+		dayBucketLocation, err = time.LoadLocation(tz)
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid DAY_BUCKET_TIMEZONE: %w", err)
+		}
+	}
+
+	encryptQueueBody, err := boolEnv("ENCRYPT_QUEUE_BODY")
+	if err != nil {
+		return Settings{}, err
+	}
+	kmsKeyID := os.Getenv("KMS_KEY_ID")
+	if encryptQueueBody && kmsKeyID == "" {
+		return Settings{}, fmt.Errorf("missing KMS_KEY_ID (required when ENCRYPT_QUEUE_BODY is set)")
+	}
+
+	drRegion := os.Getenv("DR_REGION")
+	drTable := os.Getenv("DR_TABLE")
+	if (drRegion == "") != (drTable == "") {
+		return Settings{}, fmt.Errorf("DR_REGION and DR_TABLE must be set together")
+	}
+
+	var maintenance *TimeWindow
+	if raw := os.Getenv("MAINTENANCE_WINDOW"); raw != "" {
+		window, err := parseTimeWindow(raw)
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid MAINTENANCE_WINDOW: %w", err)
+		}
+		maintenance = &window
+	}
+
+	var textTransform *transform.Transform
+	if source := os.Getenv("TRANSFORM_EXPR"); source != "" {
+		timeout := defaultTransformTimeout
+		if raw := os.Getenv("TRANSFORM_TIMEOUT_MS"); raw != "" {
+			ms, err := strconv.Atoi(raw)
+			if err != nil || ms <= 0 {
+				return Settings{}, fmt.Errorf("invalid TRANSFORM_TIMEOUT_MS %q: must be a positive integer", raw)
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+		textTransform, err = transform.Compile(source, timeout)
+		if err != nil {
+			return Settings{}, fmt.Errorf("invalid TRANSFORM_EXPR: %w", err)
+		}
+	}
+
+	enrichProvenance, err := boolEnv("ENRICH_PROVENANCE")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	responseEnvelope, err := boolEnv("RESPONSE_ENVELOPE")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	redactionAudit := os.Getenv("REDACTION_AUDIT")
+	switch redactionAudit {
+	case "", RedactionAuditCounts:
+	case RedactionAuditHashes:
+		if os.Getenv("REDACTION_HASH_KEY") == "" {
+			return Settings{}, fmt.Errorf("missing REDACTION_HASH_KEY (required when REDACTION_AUDIT=%s)", RedactionAuditHashes)
+		}
+	default:
+		return Settings{}, fmt.Errorf("invalid REDACTION_AUDIT %q: must be %s or %s", redactionAudit, RedactionAuditCounts, RedactionAuditHashes)
+	}
+
+	tenantSources, err := jsonMapEnv("TENANT_SOURCES")
+	if err != nil {
+		return Settings{}, err
+	}
+	tenantSourceStrict, err := boolEnv("TENANT_SOURCE_STRICT")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var minProcessing time.Duration
+	if raw := os.Getenv("MIN_PROCESSING_MS"); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms < 0 {
+			return Settings{}, fmt.Errorf("invalid MIN_PROCESSING_MS %q: must be a non-negative integer", raw)
+		}
+		minProcessing = time.Duration(ms) * time.Millisecond
+	}
+
+	tenantKMSKeys, err := jsonMapEnv("TENANT_KMS_KEYS")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	idempotencyTTL := defaultIdempotencyTTL
+	if raw := os.Getenv("IDEMPOTENCY_TTL_HOURS"); raw != "" {
+		hours, err := strconv.Atoi(raw)
+		if err != nil || hours <= 0 {
+			return Settings{}, fmt.Errorf("invalid IDEMPOTENCY_TTL_HOURS %q: must be a positive integer", raw)
+		}
+		idempotencyTTL = time.Duration(hours) * time.Hour
+	}
+
+	redactionDebug, err := boolEnv("REDACTION_DEBUG")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	strictJSON, err := boolEnv("STRICT_JSON")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	maxDecodedBodyBytes := int64(defaultMaxDecodedBodyBytes)
+	if raw := os.Getenv("MAX_DECODED_BODY_BYTES"); raw != "" {
+		maxDecodedBodyBytes, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || maxDecodedBodyBytes <= 0 {
+			return Settings{}, fmt.Errorf("invalid MAX_DECODED_BODY_BYTES %q: must be a positive integer", raw)
+		}
+	}
+
+	enrichTextStats, err := boolEnv("ENRICH_TEXT_STATS")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	maxFormBytes := defaultMaxFormBytes
+	if raw := os.Getenv("MAX_FORM_BYTES"); raw != "" {
+		maxFormBytes, err = strconv.Atoi(raw)
+		if err != nil || maxFormBytes <= 0 {
+			return Settings{}, fmt.Errorf("invalid MAX_FORM_BYTES %q: must be a positive integer", raw)
+		}
+	}
+
+	verifyRedaction, err := boolEnv("VERIFY_REDACTION")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	requireContentLength, err := boolEnv("REQUIRE_CONTENT_LENGTH")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	detectLanguage, err := boolEnv("DETECT_LANGUAGE")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	openSearchIndex := os.Getenv("OPENSEARCH_INDEX")
+	if openSearchIndex == "" {
+		openSearchIndex = "tenant-logs"
+	}
+
+	var tenantContentTypes map[string][]string
+	if err := jsonEnv("TENANT_CONTENT_TYPES", &tenantContentTypes); err != nil {
+		return Settings{}, err
+	}
+
+	var redactionRules []redaction.Rule
+	if location := os.Getenv("REDACTION_RULES_S3"); location != "" {
+		ttl := defaultRedactionRulesTTL
+		if raw := os.Getenv("REDACTION_RULES_TTL_SECONDS"); raw != "" {
+			seconds, err := strconv.Atoi(raw)
+			if err != nil || seconds < 0 {
+				return Settings{}, fmt.Errorf("invalid REDACTION_RULES_TTL_SECONDS %q: must be a non-negative integer", raw)
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+		redactionRules, err = remoteRedactionRules(ctx, awsCfg, location, ttl)
+	} else {
+		var redactionPatterns []string
+		if err := jsonEnv("REDACTION_PATTERNS", &redactionPatterns); err != nil {
+			return Settings{}, err
+		}
+		redactionRules, err = buildRedactionRules("REDACTION_PATTERNS", redactionPatterns)
+	}
+	if err != nil {
+		return Settings{}, err
+	}
+
+	enrichRecordID, err := boolEnv("ENRICH_RECORD_ID")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var lowPriorityDelay int32
+	if raw := os.Getenv("LOW_PRIORITY_DELAY_SECONDS"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 || seconds > 900 {
+			return Settings{}, fmt.Errorf("invalid LOW_PRIORITY_DELAY_SECONDS %q: must be an integer between 0 and 900", raw)
+		}
+		lowPriorityDelay = int32(seconds)
+	}
+
+	maxBatchRecords := defaultMaxBatchRecords
+	if raw := os.Getenv("MAX_BATCH_RECORDS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return Settings{}, fmt.Errorf("invalid MAX_BATCH_RECORDS %q: must be a positive integer", raw)
+		}
+		maxBatchRecords = n
+	}
+
+	flagRedaction, err := boolEnv("FLAG_REDACTION")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var recordTTLDays int
+	if raw := os.Getenv("RECORD_TTL_DAYS"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days <= 0 {
+			return Settings{}, fmt.Errorf("invalid RECORD_TTL_DAYS %q: must be a positive integer", raw)
+		}
+		recordTTLDays = days
+	}
+
+	var chaosCrashRate float64
+	if raw := os.Getenv("CHAOS_CRASH_RATE"); raw != "" {
+		rate, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return Settings{}, fmt.Errorf("invalid CHAOS_CRASH_RATE %q: must be a number between 0 and 1", raw)
+		}
+		chaosCrashRate = rate
+	}
+
+	apiKeys, err := jsonMapEnv("API_KEYS")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var tenantWriteRates map[string]float64
+	if err := jsonEnv("TENANT_WRITE_RATES", &tenantWriteRates); err != nil {
+		return Settings{}, err
+	}
+	for tenant, rate := range tenantWriteRates {
+		if rate <= 0 {
+			return Settings{}, fmt.Errorf("invalid TENANT_WRITE_RATES rate %v for tenant %q: must be positive", rate, tenant)
+		}
+	}
+
+	var enqueueRetryAfter int
+	if raw := os.Getenv("ENQUEUE_RETRY_AFTER_SECONDS"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			return Settings{}, fmt.Errorf("invalid ENQUEUE_RETRY_AFTER_SECONDS %q: must be a positive integer", raw)
+		}
+		enqueueRetryAfter = seconds
+	}
+
+	maxTextBytes := defaultMaxTextBytes
+	if raw := os.Getenv("MAX_TEXT_BYTES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return Settings{}, fmt.Errorf("invalid MAX_TEXT_BYTES %q: must be a positive integer", raw)
+		}
+		maxTextBytes = n
+	}
+
+	payloadBucket := os.Getenv("PAYLOAD_BUCKET")
+
+	emptyTextMode := os.Getenv("EMPTY_TEXT_MODE")
+	switch emptyTextMode {
+	case "":
+		emptyTextMode = EmptyTextStore
+	case EmptyTextStore, EmptyTextSkip, EmptyTextFail:
+	default:
+		return Settings{}, fmt.Errorf("invalid EMPTY_TEXT_MODE %q: must be %s, %s, or %s", emptyTextMode, EmptyTextStore, EmptyTextSkip, EmptyTextFail)
+	}
+
+	eventBusName := os.Getenv("EVENT_BUS_NAME")
+
+	var backpressureDepth int
+	if raw := os.Getenv("BACKPRESSURE_QUEUE_DEPTH"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return Settings{}, fmt.Errorf("invalid BACKPRESSURE_QUEUE_DEPTH %q: must be a positive integer", raw)
+		}
+		backpressureDepth = n
+	}
+
+	backpressureRetryAfter := defaultBackpressureRetryAfter
+	if raw := os.Getenv("BACKPRESSURE_RETRY_AFTER_SECONDS"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			return Settings{}, fmt.Errorf("invalid BACKPRESSURE_RETRY_AFTER_SECONDS %q: must be a positive integer", raw)
+		}
+		backpressureRetryAfter = seconds
+	}
+
+	itemEnvelopes, err := boolEnv("ITEM_ENVELOPES")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	putMaxAttempts := defaultPutMaxAttempts
+	if raw := os.Getenv("PUT_MAX_ATTEMPTS"); raw != "" {
+		putMaxAttempts, err = strconv.Atoi(raw)
+		if err != nil || putMaxAttempts < 1 {
+			return Settings{}, fmt.Errorf("invalid PUT_MAX_ATTEMPTS %q: must be a positive integer", raw)
+		}
+	}
+
+	putRetryBase := defaultPutRetryBase
+	if raw := os.Getenv("PUT_RETRY_BASE_MS"); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms < 0 {
+			return Settings{}, fmt.Errorf("invalid PUT_RETRY_BASE_MS %q: must be a non-negative integer", raw)
+		}
+		putRetryBase = time.Duration(ms) * time.Millisecond
+	}
+
+	simulateProcessing, err := boolEnv("SIMULATE_PROCESSING")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var syncMaxTextBytes int
+	if raw := os.Getenv("SYNC_MAX_TEXT_BYTES"); raw != "" {
+		syncMaxTextBytes, err = strconv.Atoi(raw)
+		if err != nil || syncMaxTextBytes < 0 {
+			return Settings{}, fmt.Errorf("invalid SYNC_MAX_TEXT_BYTES %q: must be a non-negative integer", raw)
+		}
+	}
+
+	var maxHeaderCount int
+	if raw := os.Getenv("MAX_HEADER_COUNT"); raw != "" {
+		maxHeaderCount, err = strconv.Atoi(raw)
+		if err != nil || maxHeaderCount < 0 {
+			return Settings{}, fmt.Errorf("invalid MAX_HEADER_COUNT %q: must be a non-negative integer", raw)
+		}
+	}
+
+	// FIFO queues reject per-message delays, so low priority cannot be delayed.
+	fifoQueue := strings.HasSuffix(sqsURL, ".fifo")
+	if fifoQueue && lowPriorityDelay > 0 {
+		return Settings{}, fmt.Errorf("invalid LOW_PRIORITY_DELAY_SECONDS %d: not supported with a FIFO SQS_QUEUE_URL", lowPriorityDelay)
+	}
+
+	storeLatency, err := boolEnv("STORE_LATENCY")
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var registeredTenantList []string
+	if err := jsonEnv("REGISTERED_TENANTS", &registeredTenantList); err != nil {
+		return Settings{}, err
+	}
+	var registeredTenants map[string]bool
+	if registeredTenantList != nil {
+		registeredTenants = make(map[string]bool, len(registeredTenantList))
+		for _, tenantID := range registeredTenantList {
+			registeredTenants[tenantID] = true
+		}
+	}
+
+	rollupTableName := os.Getenv("ROLLUP_TABLE_NAME")
+	var rollupOnlyList []string
+	if err := jsonEnv("ROLLUP_ONLY_TENANTS", &rollupOnlyList); err != nil {
+		return Settings{}, err
+	}
+	if len(rollupOnlyList) > 0 && rollupTableName == "" {
+		return Settings{}, fmt.Errorf("ROLLUP_ONLY_TENANTS requires ROLLUP_TABLE_NAME")
+	}
+	rollupOnlyTenants := make(map[string]bool, len(rollupOnlyList))
+	for _, tenantID := range rollupOnlyList {
+		rollupOnlyTenants[tenantID] = true
+	}
+
 	return Settings{
-		AWSConfig:         awsCfg,
-		SQSQueueURL:       sqsURL,
-		DynamoDBTableName: tableName,
+		AWSConfig:              awsCfg,
+		SQSQueueURL:            sqsURL,
+		DynamoDBTableName:      tableName,
+		RejectBinaryText:       rejectBinary,
+		DayBucketLocation:      dayBucketLocation,
+		EncryptQueueBody:       encryptQueueBody,
+		KMSKeyID:               kmsKeyID,
+		TenantKMSKeys:          tenantKMSKeys,
+		DRRegion:               drRegion,
+		DRTableName:            drTable,
+		Maintenance:            maintenance,
+		Transform:              textTransform,
+		EnrichProvenance:       enrichProvenance,
+		ResponseEnvelope:       responseEnvelope,
+		RedactionAudit:         redactionAudit,
+		RedactionHashKey:       []byte(os.Getenv("REDACTION_HASH_KEY")),
+		TenantSources:          tenantSources,
+		TenantSourceStrict:     tenantSourceStrict,
+		MinProcessing:          minProcessing,
+		OTLPEndpoint:           os.Getenv("OTLP_ENDPOINT"),
+		IdempotencyTableName:   os.Getenv("IDEMPOTENCY_TABLE_NAME"),
+		IdempotencyTTL:         idempotencyTTL,
+		RedactionDebug:         redactionDebug,
+		StrictJSON:             strictJSON,
+		MaxDecodedBodyBytes:    maxDecodedBodyBytes,
+		EnrichTextStats:        enrichTextStats,
+		MaxFormBytes:           maxFormBytes,
+		VerifyRedaction:        verifyRedaction,
+		RequireContentLength:   requireContentLength,
+		DetectLanguage:         detectLanguage,
+		OpenSearchEndpoint:     os.Getenv("OPENSEARCH_ENDPOINT"),
+		OpenSearchIndex:        openSearchIndex,
+		TenantContentTypes:     tenantContentTypes,
+		RedactionRules:         redactionRules,
+		EnrichRecordID:         enrichRecordID,
+		LowPriorityDelay:       lowPriorityDelay,
+		MaxBatchRecords:        maxBatchRecords,
+		FlagRedaction:          flagRedaction,
+		RecordTTLDays:          recordTTLDays,
+		ChaosCrashRate:         chaosCrashRate,
+		APIKeys:                apiKeys,
+		TenantWriteRates:       tenantWriteRates,
+		EnqueueRetryAfter:      enqueueRetryAfter,
+		MaxTextBytes:           maxTextBytes,
+		PayloadBucket:          payloadBucket,
+		EmptyTextMode:          emptyTextMode,
+		EventBusName:           eventBusName,
+		BackpressureDepth:      backpressureDepth,
+		BackpressureRetryAfter: backpressureRetryAfter,
+		AWSEndpointURL:         awsEndpointURL,
+		ItemEnvelopes:          itemEnvelopes,
+		PutMaxAttempts:         putMaxAttempts,
+		PutRetryBase:           putRetryBase,
+		SimulateProcessing:     simulateProcessing,
+		SyncMaxTextBytes:       syncMaxTextBytes,
+		MaxHeaderCount:         maxHeaderCount,
+		FIFOQueue:              fifoQueue,
+		StoreLatency:           storeLatency,
+		RegisteredTenants:      registeredTenants,
+		RollupTableName:        rollupTableName,
+		RollupOnlyTenants:      rollupOnlyTenants,
 	}, nil
 }
 
+// defaultMaxTextBytes is the SQS message size limit, used when
+// MAX_TEXT_BYTES is unset. Despite the name, the limit applies to the
+// serialized queue message, attributes included, not to the text alone.
+const defaultMaxTextBytes = 256 * 1024
+
+// defaultMaxDecodedBodyBytes bounds decoded and decompressed request bodies
+// when MAX_DECODED_BODY_BYTES is unset, so a gzip bomb cannot exhaust memory.
+const defaultMaxDecodedBodyBytes = 10 << 20
+
+// defaultBackpressureRetryAfter is the Retry-After, in seconds, for
+// throttled_accepted responses when BACKPRESSURE_RETRY_AFTER_SECONDS is unset.
+const defaultBackpressureRetryAfter = 5
+
+// defaultMaxBatchRecords caps how many messages one request may enqueue when
+// MAX_BATCH_RECORDS is unset.
+const defaultMaxBatchRecords = 500
+
+// defaultMaxFormBytes caps form-urlencoded bodies when MAX_FORM_BYTES is unset.
+const defaultMaxFormBytes = 64 * 1024
+
+// defaultIdempotencyTTL is how long an Idempotency-Key is remembered when
+// IDEMPOTENCY_TTL_HOURS is unset.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// defaultPutMaxAttempts is how many times a throttled or transient DynamoDB
+// write is attempted when PUT_MAX_ATTEMPTS is unset.
+const defaultPutMaxAttempts = 3
+
+// defaultPutRetryBase is the backoff before the second write attempt when
+// PUT_RETRY_BASE_MS is unset; later attempts double it.
+const defaultPutRetryBase = 50 * time.Millisecond
+
+// defaultRedactionRulesTTL is how long rules loaded from REDACTION_RULES_S3
+// are used before refetching when REDACTION_RULES_TTL_SECONDS is unset.
+const defaultRedactionRulesTTL = time.Minute
+
+// defaultTransformTimeout bounds TRANSFORM_EXPR evaluation when no timeout is configured.
+const defaultTransformTimeout = 50 * time.Millisecond
+
+// buildRedactionRules compiles patterns, or the default rules when there are
+// none, then adds the REDACT_DOB and REDACT_URL_SECRETS rules and applies
+// REDACTION_STRATEGIES.
+// source names the setting the patterns came from, for errors.
+func buildRedactionRules(source string, patterns []string) ([]redaction.Rule, error) {
+	rules := append([]redaction.Rule(nil), redaction.DefaultRules...)
+	if len(patterns) > 0 {
+		rules = nil
+		for i, pattern := range patterns {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %d %q: %w", source, i, pattern, err)
+			}
+			rules = append(rules, redaction.Rule{Name: pattern, Pattern: compiled})
+		}
+	}
+	redactDOB, err := boolEnv("REDACT_DOB")
+	if err != nil {
+		return nil, err
+	}
+	if redactDOB {
+		requireContext, err := boolEnv("DOB_REQUIRE_CONTEXT")
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, redaction.DOBRules(requireContext)...)
+	}
+	redactURLSecrets, err := boolEnv("REDACT_URL_SECRETS")
+	if err != nil {
+		return nil, err
+	}
+	if redactURLSecrets {
+		var secretParams []string
+		if err := jsonEnv("URL_SECRET_PARAMS", &secretParams); err != nil {
+			return nil, err
+		}
+		rules = append(rules, redaction.URLSecretRules(secretParams)...)
+	}
+
+	strategies, err := jsonMapEnv("REDACTION_STRATEGIES")
+	if err != nil {
+		return nil, err
+	}
+	for name, strategy := range strategies {
+		found := false
+		for i := range rules {
+			if rules[i].Name != name {
+				continue
+			}
+			replace, err := redaction.Strategy(strategy, []byte(os.Getenv("REDACTION_HASH_KEY")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid REDACTION_STRATEGIES entry for %q: %w", name, err)
+			}
+			rules[i].Replace = replace
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid REDACTION_STRATEGIES: no redaction rule named %q", name)
+		}
+	}
+	return rules, nil
+}
+
+// jsonMapEnv parses an optional environment variable holding a JSON object of strings.
+func jsonMapEnv(name string) (map[string]string, error) {
+	var value map[string]string
+	if err := jsonEnv(name, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// jsonEnv decodes an optional JSON environment variable into target, leaving
+// it untouched when the variable is unset.
+func jsonEnv(name string, target any) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(raw), target); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+// parseTimeWindow parses an RFC3339 interval of the form "start/end".
+func parseTimeWindow(raw string) (TimeWindow, error) {
+	startRaw, endRaw, ok := strings.Cut(raw, "/")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("expected start/end, got %q", raw)
+	}
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(startRaw))
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("start: %w", err)
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(endRaw))
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("end: %w", err)
+	}
+	if !end.After(start) {
+		return TimeWindow{}, fmt.Errorf("end must be after start")
+	}
+	return TimeWindow{Start: start, End: end}, nil
+}
+
+// KMSKeyFor returns the KMS key used to encrypt messages for tenantID.
+func (s Settings) KMSKeyFor(tenantID string) string {
+	if keyID, ok := s.TenantKMSKeys[tenantID]; ok && keyID != "" {
+		return keyID
+	}
+	return s.KMSKeyID
+}
+
+// boolEnv parses an optional boolean environment variable, defaulting to false.
+func boolEnv(name string) (bool, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return value, nil
+}
+
@@ -0,0 +1,315 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"memory-machine/internal/redaction"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{"valid", "2026-03-01T02:00:00Z/2026-03-01T03:00:00Z", false},
+		{"offsets and spaces", "2026-03-01T04:00:00+02:00 / 2026-03-01T05:00:00+02:00", false},
+		{"missing separator", "2026-03-01T02:00:00Z", true},
+		{"bad start", "yesterday/2026-03-01T03:00:00Z", true},
+		{"end before start", "2026-03-01T03:00:00Z/2026-03-01T02:00:00Z", true},
+		{"empty window", "2026-03-01T02:00:00Z/2026-03-01T02:00:00Z", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := parseTimeWindow(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimeWindow(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			}
+			if err == nil && window.End.Sub(window.Start) != time.Hour {
+				t.Errorf("window = %v, want one hour", window)
+			}
+		})
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	window := TimeWindow{Start: start, End: start.Add(time.Hour)}
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{start.Add(-time.Nanosecond), false},
+		{start, true},
+		{start.Add(59 * time.Minute), true},
+		{start.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		if got := window.Contains(tt.at); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestKMSKeyFor(t *testing.T) {
+	settings := Settings{
+		KMSKeyID:      "alias/default",
+		TenantKMSKeys: map[string]string{"acme": "alias/acme", "globex": "alias/globex", "blank": ""},
+	}
+	for tenant, want := range map[string]string{
+		"acme":    "alias/acme",
+		"globex":  "alias/globex",
+		"initech": "alias/default",
+		"blank":   "alias/default",
+	} {
+		if got := settings.KMSKeyFor(tenant); got != want {
+			t.Errorf("KMSKeyFor(%q) = %q, want %q", tenant, got, want)
+		}
+	}
+}
+
+// setRequiredEnv sets the variables Load requires, with AWS config files
+// pointed somewhere empty.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("SQS_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/000000000000/ingest")
+	t.Setenv("DYNAMODB_TABLE_NAME", "tenant-logs")
+}
+
+func TestLoadRedactionPatterns(t *testing.T) {
+	tests := []struct {
+		name      string
+		patterns  string
+		wantRules []string
+		wantErr   string
+	}{
+		{"unset keeps the defaults", "", []string{"email", "phone"}, ""},
+		{"custom patterns replace the defaults", `["\\d{3}-\\d{2}-\\d{4}", "secret-\\w+"]`, []string{`\d{3}-\d{2}-\d{4}`, `secret-\w+`}, ""},
+		{"invalid regex", `["ok", "(unclosed"]`, nil, `invalid REDACTION_PATTERNS entry 1 "(unclosed"`},
+		{"not a JSON array", `\d+`, nil, "REDACTION_PATTERNS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("REDACTION_PATTERNS", tt.patterns)
+
+			settings, err := Load(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			var names []string
+			for _, rule := range settings.RedactionRules {
+				names = append(names, rule.Name)
+			}
+			if strings.Join(names, " ") != strings.Join(tt.wantRules, " ") {
+				t.Errorf("rules = %q, want %q", names, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestLoadChaosCrashRate(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"0.25", 0.25, false},
+		{"1", 1, false},
+		{"1.5", 0, true},
+		{"-0.1", 0, true},
+		{"often", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run("CHAOS_CRASH_RATE="+tt.raw, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("CHAOS_CRASH_RATE", tt.raw)
+
+			settings, err := Load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && settings.ChaosCrashRate != tt.want {
+				t.Errorf("ChaosCrashRate = %v, want %v", settings.ChaosCrashRate, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmptyTextMode(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"", EmptyTextStore, false},
+		{"store", EmptyTextStore, false},
+		{"skip", EmptyTextSkip, false},
+		{"fail", EmptyTextFail, false},
+		{"drop", "", true},
+	}
+	for _, tt := range tests {
+		t.Run("EMPTY_TEXT_MODE="+tt.raw, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("EMPTY_TEXT_MODE", tt.raw)
+
+			settings, err := Load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && settings.EmptyTextMode != tt.want {
+				t.Errorf("EmptyTextMode = %q, want %q", settings.EmptyTextMode, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRedactionStrategies(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategies string
+		want       string
+		wantErr    string
+	}{
+		{"keep-domain", `{"email":"keep-domain"}`, "mail ****@example.com, call [REDACTED]", ""},
+		{"mask", `{"email":"mask","phone":"mask"}`, "mail ****************, call ********", ""},
+		{"unknown rule", `{"ssn":"mask"}`, "", `no redaction rule named "ssn"`},
+		{"unknown strategy", `{"email":"shred"}`, "", `unknown strategy "shred"`},
+		{"hash without a key", `{"email":"hash"}`, "", "requires a key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("REDACTION_STRATEGIES", tt.strategies)
+
+			settings, err := Load(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if got := redaction.Apply("mail jane@example.com, call 555-1234", settings.RedactionRules).Text; got != tt.want {
+				t.Errorf("Apply = %q, want %q", got, tt.want)
+			}
+			if redaction.DefaultRules[0].Replace != nil {
+				t.Error("Load changed redaction.DefaultRules")
+			}
+		})
+	}
+}
+
+func TestLoadAWSEndpointURL(t *testing.T) {
+	var mu sync.Mutex
+	var targets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+
+	setRequiredEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	settings, err := Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if settings.AWSEndpointURL != server.URL || aws.ToString(settings.AWSConfig.BaseEndpoint) != server.URL {
+		t.Fatalf("endpoint = %q, base endpoint = %q; want %s", settings.AWSEndpointURL, aws.ToString(settings.AWSConfig.BaseEndpoint), server.URL)
+	}
+
+	// Both pipeline clients built from the shared config reach the override.
+	if _, err := sqs.NewFromConfig(settings.AWSConfig).GetQueueAttributes(context.Background(), &sqs.GetQueueAttributesInput{QueueUrl: &settings.SQSQueueURL}); err != nil {
+		t.Errorf("SQS call: %v", err)
+	}
+	if _, err := dynamodb.NewFromConfig(settings.AWSConfig).DescribeTable(context.Background(), &dynamodb.DescribeTableInput{TableName: &settings.DynamoDBTableName}); err != nil {
+		t.Errorf("DynamoDB call: %v", err)
+	}
+	want := []string{"AmazonSQS.GetQueueAttributes", "DynamoDB_20120810.DescribeTable"}
+	if strings.Join(targets, " ") != strings.Join(want, " ") {
+		t.Errorf("endpoint received %v, want %v", targets, want)
+	}
+}
+
+func TestLoadInvalidAWSEndpointURL(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AWS_ENDPOINT_URL", "localhost:4566")
+	if _, err := Load(context.Background()); err == nil || !strings.Contains(err.Error(), "AWS_ENDPOINT_URL") {
+		t.Errorf("Load error = %v, want one naming AWS_ENDPOINT_URL", err)
+	}
+}
+
+func TestLoadSimulateProcessing(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want bool
+	}{{"", false}, {"false", false}, {"true", true}} {
+		t.Run("SIMULATE_PROCESSING="+tt.raw, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("SIMULATE_PROCESSING", tt.raw)
+			settings, err := Load(context.Background())
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if settings.SimulateProcessing != tt.want {
+				t.Errorf("SimulateProcessing = %v, want %v", settings.SimulateProcessing, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMaxTextBytes(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want int
+	}{{"", 256 * 1024}, {"1024", 1024}} {
+		t.Run("MAX_TEXT_BYTES="+tt.raw, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("MAX_TEXT_BYTES", tt.raw)
+			settings, err := Load(context.Background())
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if settings.MaxTextBytes != tt.want {
+				t.Errorf("MaxTextBytes = %d, want %d", settings.MaxTextBytes, tt.want)
+			}
+		})
+	}
+
+	setRequiredEnv(t)
+	t.Setenv("MAX_TEXT_BYTES", "0")
+	if _, err := Load(context.Background()); err == nil || !strings.Contains(err.Error(), "MAX_TEXT_BYTES") {
+		t.Errorf("Load error = %v, want one naming MAX_TEXT_BYTES", err)
+	}
+}
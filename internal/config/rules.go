@@ -0,0 +1,100 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"memory-machine/internal/redaction"
+)
+
+// maxRedactionRulesBytes bounds the REDACTION_RULES_S3 object.
+const maxRedactionRulesBytes = 1 << 20
+
+// redactionRulesCache holds the last good ruleset loaded from
+// REDACTION_RULES_S3. It lives for the whole Lambda container, so warm
+// invocations only refetch once the TTL has passed.
+var redactionRulesCache struct {
+	mu       sync.Mutex
+	location string
+	rules    []redaction.Rule
+	checked  time.Time
+}
+
+// remoteRedactionRules returns the ruleset stored at location, an
+// s3://bucket/key URL, refetching it once ttl has passed. A refresh that fails
+// or yields an invalid ruleset is logged and the last good ruleset is kept;
+// only a container with no good ruleset yet returns the error.
+func remoteRedactionRules(ctx context.Context, awsCfg aws.Config, location string, ttl time.Duration) ([]redaction.Rule, error) {
+	bucket, key, err := parseS3Location(location)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &redactionRulesCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.location == location && time.Since(cache.checked) < ttl {
+		return cache.rules, nil
+	}
+
+	rules, err := fetchRedactionRules(ctx, awsCfg, bucket, key)
+	if err != nil {
+		if cache.location != location {
+			return nil, err
+		}
+		slog.Warn("redaction rules refresh rejected, keeping last good set", "location", location, "error", err)
+		cache.checked = time.Now()
+		return cache.rules, nil
+	}
+	cache.location, cache.rules, cache.checked = location, rules, time.Now()
+	return rules, nil
+}
+
+// fetchRedactionRules downloads a JSON array of patterns, in the same form as
+// REDACTION_PATTERNS, and builds the ruleset from it.
+func fetchRedactionRules(ctx context.Context, awsCfg aws.Config, bucket, key string) ([]redaction.Rule, error) {
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.UsePathStyle = awsCfg.BaseEndpoint != nil
+	})
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, fmt.Errorf("fetch REDACTION_RULES_S3: %w", err)
+	}
+	defer out.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(out.Body, maxRedactionRulesBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read REDACTION_RULES_S3: %w", err)
+	}
+	if len(body) > maxRedactionRulesBytes {
+		return nil, fmt.Errorf("invalid REDACTION_RULES_S3: object exceeds %d bytes", maxRedactionRulesBytes)
+	}
+
+	var patterns []string
+	if err := json.Unmarshal(body, &patterns); err != nil {
+		return nil, fmt.Errorf("invalid REDACTION_RULES_S3: %w", err)
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("invalid REDACTION_RULES_S3: must list at least one pattern")
+	}
+	return buildRedactionRules("REDACTION_RULES_S3", patterns)
+}
+
+// parseS3Location splits an s3://bucket/key URL.
+func parseS3Location(location string) (bucket, key string, err error) {
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" || strings.Trim(parsed.Path, "/") == "" {
+		return "", "", fmt.Errorf("invalid REDACTION_RULES_S3 %q: must be s3://bucket/key", location)
+	}
+	return parsed.Host, strings.TrimPrefix(parsed.Path, "/"), nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRulesBucket serves one S3 object, the current ruleset, at
+// /rules-bucket/redaction.json and counts the requests for it.
+type fakeRulesBucket struct {
+	mu       sync.Mutex
+	body     string
+	requests int
+}
+
+func (f *fakeRulesBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/rules-bucket/redaction.json" {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`))
+		return
+	}
+	f.requests++
+	_, _ = w.Write([]byte(f.body))
+}
+
+func (f *fakeRulesBucket) set(body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.body = body
+}
+
+// useRulesBucket points REDACTION_RULES_S3 at a fresh fakeRulesBucket with
+// the given TTL and clears the container's cached ruleset.
+func useRulesBucket(t *testing.T, ttlSeconds string) *fakeRulesBucket {
+	t.Helper()
+	fake := &fakeRulesBucket{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	setRequiredEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("REDACTION_RULES_S3", "s3://rules-bucket/redaction.json")
+	t.Setenv("REDACTION_RULES_TTL_SECONDS", ttlSeconds)
+
+	resetRulesCache()
+	t.Cleanup(resetRulesCache)
+	return fake
+}
+
+func resetRulesCache() {
+	redactionRulesCache.mu.Lock()
+	defer redactionRulesCache.mu.Unlock()
+	redactionRulesCache.location, redactionRulesCache.rules, redactionRulesCache.checked = "", nil, time.Time{}
+}
+
+// loadRuleNames runs Load and returns the names of the redaction rules.
+func loadRuleNames(t *testing.T) []string {
+	t.Helper()
+	settings, err := Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var names []string
+	for _, rule := range settings.RedactionRules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+func TestRemoteRedactionRulesRefresh(t *testing.T) {
+	fake := useRulesBucket(t, "0")
+
+	fake.set(`["secret-\\w+"]`)
+	if names := loadRuleNames(t); strings.Join(names, " ") != `secret-\w+` {
+		t.Fatalf("loaded rules %q, want the S3 ruleset", names)
+	}
+
+	fake.set(`["token-\\d+", "key-\\w+"]`)
+	if names := loadRuleNames(t); strings.Join(names, " ") != `token-\d+ key-\w+` {
+		t.Fatalf("refreshed rules %q, want the updated ruleset", names)
+	}
+
+	for _, invalid := range []string{`["(unclosed"]`, `[]`, `not json`} {
+		fake.set(invalid)
+		if names := loadRuleNames(t); strings.Join(names, " ") != `token-\d+ key-\w+` {
+			t.Errorf("after invalid refresh %s, rules = %q, want the last good ruleset", invalid, names)
+		}
+	}
+	if fake.requests != 5 {
+		t.Errorf("fetched the ruleset %d times, want every Load with a zero TTL", fake.requests)
+	}
+}
+
+func TestRemoteRedactionRulesCachedWithinTTL(t *testing.T) {
+	fake := useRulesBucket(t, "3600")
+
+	fake.set(`["secret-\\w+"]`)
+	loadRuleNames(t)
+	fake.set(`["token-\\d+"]`)
+	if names := loadRuleNames(t); strings.Join(names, " ") != `secret-\w+` {
+		t.Errorf("rules within the TTL = %q, want the cached ruleset", names)
+	}
+	if fake.requests != 1 {
+		t.Errorf("fetched the ruleset %d times, want 1", fake.requests)
+	}
+}
+
+func TestRemoteRedactionRulesInvalidFirstLoad(t *testing.T) {
+	fake := useRulesBucket(t, "0")
+	fake.set(`["(unclosed"]`)
+	if _, err := Load(context.Background()); err == nil || !strings.Contains(err.Error(), "REDACTION_RULES_S3") {
+		t.Errorf("Load error = %v, want the invalid ruleset reported", err)
+	}
+
+	t.Setenv("REDACTION_RULES_S3", "s3://rules-bucket/missing.json")
+	if _, err := Load(context.Background()); err == nil {
+		t.Error("Load succeeded with a missing ruleset object")
+	}
+
+	t.Setenv("REDACTION_RULES_S3", "https://rules-bucket/redaction.json")
+	if _, err := Load(context.Background()); err == nil || !strings.Contains(err.Error(), "s3://bucket/key") {
+		t.Errorf("Load error = %v, want the location format reported", err)
+	}
+}
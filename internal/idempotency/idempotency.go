@@ -0,0 +1,189 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Record is what the store remembers about an accepted request.
+type Record struct {
+	TenantID string
+	LogID    string
+	// Response is the exact response body returned to the first request.
+	Response []byte
+	// Pending is set on an existing claim whose request has not completed yet.
+	Pending bool
+}
+
+// Store deduplicates requests by Idempotency-Key across Lambda containers.
+// Keys are scoped to a tenant: the same key sent by two tenants never collides.
+//
+// A claim is pending until Complete is called, and only completed claims are
+// replayed. A request that dies between Claim and Complete leaves a pending
+// claim behind, which becomes claimable again once it is stale.
+type Store interface {
+	// Claim reserves key for record.TenantID as a pending claim. If the tenant
+	// holds a completed or a fresh pending claim for the key, it returns that
+	// record and leaves the store unchanged.
+	Claim(ctx context.Context, key string, record Record) (existing *Record, err error)
+	// Complete marks the tenant's claim as completed, so repeats replay it.
+	Complete(ctx context.Context, tenantID, key string) error
+	// Release forgets the tenant's key so a failed request can be retried.
+	Release(ctx context.Context, tenantID, key string) error
+}
+
+// PendingTimeout is how long a pending claim blocks the key. It exceeds the
+// ingest Lambda's timeout, so an older pending claim belongs to a request that
+// can no longer complete.
+const PendingTimeout = time.Minute
+
+// Claim statuses. Claims stored before statuses existed have none and were
+// only written once enqueued, so they count as completed.
+const (
+	statusPending   = "pending"
+	statusCompleted = "completed"
+)
+
+// ScopedKey is the stored form of a tenant's idempotency key.
+func ScopedKey(tenantID, key string) string {
+	return tenantID + "|" + key
+}
+
+// DynamoDBAPI is the subset of the DynamoDB client used by DynamoStore.
+type DynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// DynamoStore is a Store backed by a table keyed on idempotency_key, with an
+// expires_at epoch attribute suitable for DynamoDB TTL and a status and
+// claimed_at for pending claims.
+type DynamoStore struct {
+	client DynamoDBAPI
+	table  string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewDynamoStore returns a DynamoStore whose claims expire after ttl.
+func NewDynamoStore(client DynamoDBAPI, table string, ttl time.Duration) *DynamoStore {
+	return &DynamoStore{client: client, table: table, ttl: ttl, now: time.Now}
+}
+
+// Claim implements Store. TTL deletion is lazy, so expired claims are treated
+// as absent rather than waiting for DynamoDB to remove them.
+func (s *DynamoStore) Claim(ctx context.Context, key string, record Record) (*Record, error) {
+	current := s.now()
+	item := map[string]types.AttributeValue{
+		"idempotency_key": &types.AttributeValueMemberS{Value: ScopedKey(record.TenantID, key)},
+		"tenant_id":       &types.AttributeValueMemberS{Value: record.TenantID},
+		"log_id":          &types.AttributeValueMemberS{Value: record.LogID},
+		"expires_at":      epoch(current.Add(s.ttl)),
+		"status":          &types.AttributeValueMemberS{Value: statusPending},
+		"claimed_at":      epoch(current),
+	}
+	if len(record.Response) > 0 {
+		item["response"] = &types.AttributeValueMemberB{Value: record.Response}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                &s.table,
+		Item:                     item,
+		ConditionExpression:      stringPtr("attribute_not_exists(idempotency_key) OR expires_at < :now OR (#status = :pending AND claimed_at < :stale)"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":     epoch(current),
+			":pending": &types.AttributeValueMemberS{Value: statusPending},
+			":stale":   epoch(current.Add(-PendingTimeout)),
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+	var cfe *types.ConditionalCheckFailedException
+	if !errors.As(err, &cfe) {
+		return nil, fmt.Errorf("claim idempotency key: %w", err)
+	}
+
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &s.table,
+		Key:            itemKey(ScopedKey(record.TenantID, key)),
+		ConsistentRead: boolPtr(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read idempotency key: %w", err)
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("read idempotency key: claim for %q disappeared", key)
+	}
+	existing := &Record{
+		TenantID: stringValue(out.Item["tenant_id"]),
+		LogID:    stringValue(out.Item["log_id"]),
+		Pending:  stringValue(out.Item["status"]) == statusPending,
+	}
+	if response, ok := out.Item["response"].(*types.AttributeValueMemberB); ok {
+		existing.Response = response.Value
+	}
+	return existing, nil
+}
+
+// Complete implements Store. The condition keeps a claim released in the
+// meantime from being recreated without its other attributes.
+func (s *DynamoStore) Complete(ctx context.Context, tenantID, key string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 &s.table,
+		Key:                       itemKey(ScopedKey(tenantID, key)),
+		UpdateExpression:          stringPtr("SET #status = :completed"),
+		ConditionExpression:       stringPtr("attribute_exists(idempotency_key)"),
+		ExpressionAttributeNames:  map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":completed": &types.AttributeValueMemberS{Value: statusCompleted}},
+	})
+	if err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Release implements Store.
+func (s *DynamoStore) Release(ctx context.Context, tenantID, key string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.table,
+		Key:       itemKey(ScopedKey(tenantID, key)),
+	})
+	if err != nil {
+		return fmt.Errorf("release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func itemKey(scopedKey string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"idempotency_key": &types.AttributeValueMemberS{Value: scopedKey},
+	}
+}
+
+func epoch(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func stringValue(av types.AttributeValue) string {
+	if s, ok := av.(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
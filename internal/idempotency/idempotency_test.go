@@ -0,0 +1,197 @@
+package idempotency
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeTable is an in-memory idempotency table. PutItem applies the claim
+// condition DynamoStore sends: the key is absent, expired, or a stale pending
+// claim.
+type fakeTable struct {
+	items map[string]map[string]types.AttributeValue
+}
+
+func newFakeTable() *fakeTable {
+	return &fakeTable{items: map[string]map[string]types.AttributeValue{}}
+}
+
+func (f *fakeTable) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	key := stringValue(params.Item["idempotency_key"])
+	if current, ok := f.items[key]; ok {
+		values := params.ExpressionAttributeValues
+		expired := number(current["expires_at"]) < number(values[":now"])
+		stale := stringValue(current["status"]) == statusPending && number(current["claimed_at"]) < number(values[":stale"])
+		if !expired && !stale {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	f.items[key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeTable) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	current, ok := f.items[stringValue(params.Key["idempotency_key"])]
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	current["status"] = params.ExpressionAttributeValues[":completed"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeTable) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.items[stringValue(params.Key["idempotency_key"])]}, nil
+}
+
+func (f *fakeTable) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	delete(f.items, stringValue(params.Key["idempotency_key"]))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func number(av types.AttributeValue) int64 {
+	n, _ := av.(*types.AttributeValueMemberN)
+	if n == nil {
+		return 0
+	}
+	value, _ := strconv.ParseInt(n.Value, 10, 64)
+	return value
+}
+
+// newTestStore returns a DynamoStore over a fresh fakeTable whose clock the
+// test advances through the returned pointer.
+func newTestStore() (*DynamoStore, *fakeTable, *time.Time) {
+	table := newFakeTable()
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := NewDynamoStore(table, "idempotency", 24*time.Hour)
+	store.now = func() time.Time { return clock }
+	return store, table, &clock
+}
+
+func claim(t *testing.T, store *DynamoStore, tenant, logID string) *Record {
+	t.Helper()
+	existing, err := store.Claim(context.Background(), "key-1", Record{TenantID: tenant, LogID: logID, Response: []byte(`{"log_id":"` + logID + `"}`)})
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	return existing
+}
+
+func TestClaimReplaysOnlyCompletedClaims(t *testing.T) {
+	store, _, _ := newTestStore()
+	if existing := claim(t, store, "acme", "log-1"); existing != nil {
+		t.Fatalf("first claim returned %+v, want nil", existing)
+	}
+
+	existing := claim(t, store, "acme", "log-2")
+	if existing == nil || !existing.Pending {
+		t.Fatalf("repeat before Complete = %+v, want a pending record", existing)
+	}
+
+	if err := store.Complete(context.Background(), "acme", "key-1"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	existing = claim(t, store, "acme", "log-2")
+	if existing == nil || existing.Pending {
+		t.Fatalf("repeat after Complete = %+v, want a completed record", existing)
+	}
+	if existing.LogID != "log-1" || string(existing.Response) != `{"log_id":"log-1"}` {
+		t.Errorf("replayed %+v, want the first request's log_id and response", existing)
+	}
+}
+
+func TestClaimReclaimsStalePending(t *testing.T) {
+	store, _, clock := newTestStore()
+	claim(t, store, "acme", "log-1")
+
+	*clock = clock.Add(PendingTimeout / 2)
+	if existing := claim(t, store, "acme", "log-2"); existing == nil || !existing.Pending {
+		t.Fatalf("claim within PendingTimeout = %+v, want the pending record", existing)
+	}
+
+	*clock = clock.Add(PendingTimeout)
+	if existing := claim(t, store, "acme", "log-2"); existing != nil {
+		t.Fatalf("claim after PendingTimeout = %+v, want nil", existing)
+	}
+	if err := store.Complete(context.Background(), "acme", "key-1"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if existing := claim(t, store, "acme", "log-3"); existing == nil || existing.LogID != "log-2" {
+		t.Errorf("repeat = %+v, want the reclaiming request's log-2", existing)
+	}
+}
+
+func TestCompletedClaimIsNotStale(t *testing.T) {
+	store, _, clock := newTestStore()
+	claim(t, store, "acme", "log-1")
+	if err := store.Complete(context.Background(), "acme", "key-1"); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	*clock = clock.Add(time.Hour)
+	if existing := claim(t, store, "acme", "log-2"); existing == nil || existing.LogID != "log-1" {
+		t.Fatalf("repeat within the TTL = %+v, want log-1 replayed", existing)
+	}
+	*clock = clock.Add(24 * time.Hour)
+	if existing := claim(t, store, "acme", "log-3"); existing != nil {
+		t.Errorf("claim after the TTL = %+v, want nil", existing)
+	}
+}
+
+func TestLegacyClaimWithoutStatusIsCompleted(t *testing.T) {
+	store, table, _ := newTestStore()
+	table.items[ScopedKey("acme", "key-1")] = map[string]types.AttributeValue{
+		"idempotency_key": &types.AttributeValueMemberS{Value: ScopedKey("acme", "key-1")},
+		"tenant_id":       &types.AttributeValueMemberS{Value: "acme"},
+		"log_id":          &types.AttributeValueMemberS{Value: "log-old"},
+		"expires_at":      epoch(store.now().Add(time.Hour)),
+	}
+	if existing := claim(t, store, "acme", "log-2"); existing == nil || existing.Pending || existing.LogID != "log-old" {
+		t.Errorf("claim over a legacy item = %+v, want log-old replayed", existing)
+	}
+}
+
+func TestClaimIsScopedToTenant(t *testing.T) {
+	store, _, _ := newTestStore()
+	claim(t, store, "acme", "log-1")
+	if existing := claim(t, store, "globex", "log-2"); existing != nil {
+		t.Errorf("another tenant's claim of the same key returned %+v, want nil", existing)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	store, table, _ := newTestStore()
+	claim(t, store, "acme", "log-1")
+	if err := store.Release(context.Background(), "acme", "key-1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if existing := claim(t, store, "acme", "log-2"); existing != nil {
+		t.Fatalf("claim after Release = %+v, want nil", existing)
+	}
+
+	if err := store.Release(context.Background(), "acme", "key-1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := store.Complete(context.Background(), "acme", "key-1"); err == nil {
+		t.Error("Complete recreated a released claim")
+	}
+	if len(table.items) != 0 {
+		t.Errorf("table has %d items after Release, want 0", len(table.items))
+	}
+}
+
+func TestClaimStoresTenantScopedKey(t *testing.T) {
+	store, table, _ := newTestStore()
+	claim(t, store, "acme", "log-1")
+	claim(t, store, "globex", "log-2")
+
+	for _, key := range []string{"acme|key-1", "globex|key-1"} {
+		if _, ok := table.items[key]; !ok {
+			t.Errorf("no item stored under %q", key)
+		}
+	}
+}
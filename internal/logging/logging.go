@@ -0,0 +1,25 @@
+package logging
+
+import (
+	"log/slog"
+	"os"
+
+	"memory-machine/internal/models"
+)
+
+// New returns a logger that writes one JSON object per line to stdout, which
+// CloudWatch Logs Insights parses into fields such as level and msg.
+func New() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+}
+
+// Message returns the default logger annotated with the fields that identify
+// message, so every line about a record can be queried the same way.
+func Message(message models.InternalMessage) *slog.Logger {
+	return slog.Default().With(
+		"tenant_id", message.TenantID,
+		"log_id", message.LogID,
+		"source", message.Source,
+		"trace_id", message.TraceID,
+	)
+}
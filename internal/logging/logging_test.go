@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"memory-machine/internal/models"
+)
+
+func TestMessage(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	message := models.NewInternalMessage("acme", "log-1", "json_upload", "call 555-1234")
+	message.TraceID = "trace-1"
+	Message(message).Warn("duplicate log_id", "attempt", 2)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+	}
+	want := map[string]any{
+		"level":     "WARN",
+		"msg":       "duplicate log_id",
+		"tenant_id": "acme",
+		"log_id":    "log-1",
+		"source":    "json_upload",
+		"trace_id":  "trace-1",
+		"attempt":   float64(2),
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s = %v, want %v", key, line[key], value)
+		}
+	}
+	if _, ok := line["text"]; ok {
+		t.Error("log line includes the message text")
+	}
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Namespace is the CloudWatch namespace for all custom metrics.
+const Namespace = "RobustDataProcessor"
+
+// output receives EMF records; Lambda forwards stdout to CloudWatch Logs,
+// which extracts the metrics without any PutMetricData calls.
+var output io.Writer = os.Stdout
+
+// SetOutput sends EMF records to w instead of stdout. Call it before any
+// metrics are flushed, for example to capture them in tests.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// series is one buffered metric: a name, its dimensions, and the summed value.
+type series struct {
+	name       string
+	dimensions map[string]string
+	value      float64
+}
+
+var (
+	mu     sync.Mutex
+	buffer = make(map[string]*series)
+	order  []string
+)
+
+// Count adds value to the Count metric name, dimensioned by every key in
+// dimensions. Values are buffered and summed per name and dimension set until
+// Flush writes them.
+func Count(name string, value float64, dimensions map[string]string) {
+	key := seriesKey(name, dimensions)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if s, ok := buffer[key]; ok {
+		s.value += value
+		return
+	}
+	copied := make(map[string]string, len(dimensions))
+	for k, v := range dimensions {
+		copied[k] = v
+	}
+	buffer[key] = &series{name: name, dimensions: copied, value: value}
+	order = append(order, key)
+}
+
+// Flush writes every buffered metric in Embedded Metric Format, one line per
+// series, and empties the buffer. Handlers call it before returning, since a
+// frozen Lambda would otherwise lose whatever is still buffered.
+func Flush() {
+	mu.Lock()
+	pending, keys, w := buffer, order, output
+	buffer, order = make(map[string]*series), nil
+	mu.Unlock()
+
+	timestamp := time.Now().UnixMilli()
+	for _, key := range keys {
+		write(w, pending[key], timestamp)
+	}
+}
+
+func write(w io.Writer, s *series, timestamp int64) {
+	keys := make([]string, 0, len(s.dimensions))
+	for key := range s.dimensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	record := map[string]any{
+		"_aws": map[string]any{
+			"Timestamp": timestamp,
+			"CloudWatchMetrics": []map[string]any{{
+				"Namespace":  Namespace,
+				"Dimensions": [][]string{keys},
+				"Metrics":    []map[string]string{{"Name": s.name, "Unit": "Count"}},
+			}},
+		},
+		s.name: s.value,
+	}
+	for key, dimValue := range s.dimensions {
+		record[key] = dimValue
+	}
+
+	line, _ := json.Marshal(record)
+	fmt.Fprintln(w, string(line))
+}
+
+// seriesKey identifies a metric by name and sorted dimensions.
+func seriesKey(name string, dimensions map[string]string) string {
+	parts := make([]string, 0, len(dimensions))
+	for key, value := range dimensions {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return name + "\x00" + strings.Join(parts, "\x00")
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// captureOutput sends EMF records to a buffer for the rest of the test and
+// returns a function decoding the lines flushed so far.
+func captureOutput(t *testing.T) func() []map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := output
+	SetOutput(&buf)
+	t.Cleanup(func() { SetOutput(previous) })
+	return func() []map[string]any {
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("EMF line is not JSON: %v: %s", err, line)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+func TestFlushWritesEMF(t *testing.T) {
+	records := captureOutput(t)
+	Count("RecordsProcessed", 1, map[string]string{"TenantID": "acme"})
+	Count("RedactionsApplied", 3, map[string]string{"TenantID": "acme"})
+	Flush()
+
+	got := records()
+	if len(got) != 2 {
+		t.Fatalf("flushed %d records, want 2", len(got))
+	}
+	record := got[1]
+	if record["RedactionsApplied"] != float64(3) || record["TenantID"] != "acme" {
+		t.Errorf("record = %v, want RedactionsApplied 3 for acme", record)
+	}
+	aws := record["_aws"].(map[string]any)
+	if _, ok := aws["Timestamp"].(float64); !ok {
+		t.Errorf("_aws.Timestamp = %v, want epoch milliseconds", aws["Timestamp"])
+	}
+	directive := aws["CloudWatchMetrics"].([]any)[0].(map[string]any)
+	if directive["Namespace"] != Namespace {
+		t.Errorf("Namespace = %v, want %s", directive["Namespace"], Namespace)
+	}
+	if dims, _ := json.Marshal(directive["Dimensions"]); string(dims) != `[["TenantID"]]` {
+		t.Errorf("Dimensions = %s, want [[\"TenantID\"]]", dims)
+	}
+	if metrics, _ := json.Marshal(directive["Metrics"]); string(metrics) != `[{"Name":"RedactionsApplied","Unit":"Count"}]` {
+		t.Errorf("Metrics = %s", metrics)
+	}
+}
+
+func TestCountSumsPerSeries(t *testing.T) {
+	records := captureOutput(t)
+	Count("DuplicatesDetected", 1, map[string]string{"TenantID": "acme"})
+	Count("DuplicatesDetected", 1, map[string]string{"TenantID": "globex"})
+	Count("DuplicatesDetected", 1, map[string]string{"TenantID": "acme"})
+	Flush()
+
+	got := records()
+	if len(got) != 2 {
+		t.Fatalf("flushed %d records, want one per tenant", len(got))
+	}
+	for i, want := range []struct {
+		tenant string
+		value  float64
+	}{{"acme", 2}, {"globex", 1}} {
+		if got[i]["TenantID"] != want.tenant || got[i]["DuplicatesDetected"] != want.value {
+			t.Errorf("record %d = %v, want %v for %s", i, got[i], want.value, want.tenant)
+		}
+	}
+
+	Flush()
+	if len(records()) != 2 {
+		t.Error("second Flush wrote records again")
+	}
+}
@@ -1,7 +1,13 @@
 package models
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // JSONIngestRequest matches the JSON ingest payload.
@@ -11,13 +17,104 @@ type JSONIngestRequest struct {
 	LogID    string `json:"log_id,omitempty"`
 }
 
+// XMLIngestRequest mirrors JSONIngestRequest for XML uploads. The root element
+// may have any name.
+type XMLIngestRequest struct {
+	TenantID string `xml:"tenant_id"`
+	Text     string `xml:"text"`
+	LogID    string `xml:"log_id"`
+}
+
+// ItemEnvelope is a JSON upload carrying several items, each in its own
+// format. Items share the envelope's tenant.
+type ItemEnvelope struct {
+	TenantID string         `json:"tenant_id"`
+	Items    []EnvelopeItem `json:"items"`
+}
+
+// EnvelopeItem is one item of an ItemEnvelope: a text item sets Text, a json
+// item sets Data to an object.
+type EnvelopeItem struct {
+	Format string          `json:"format"`
+	LogID  string          `json:"log_id,omitempty"`
+	Text   string          `json:"text,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// Envelope item formats.
+const (
+	ItemFormatText = "text"
+	ItemFormatJSON = "json"
+)
+
+// SQS message attribute names mirroring InternalMessage routing fields.
+const (
+	AttributeTenantID = "tenant_id"
+	AttributeSource   = "source"
+)
+
 // InternalMessage is the normalized structure sent to SQS.
 type InternalMessage struct {
-	TenantID   string    `json:"tenant_id"`
-	LogID      string    `json:"log_id"`
-	Source     string    `json:"source"`
-	Text       string    `json:"text"`
-	ReceivedAt time.Time `json:"received_at"`
+	TenantID     string    `json:"tenant_id"`
+	LogID        string    `json:"log_id"`
+	Source       string    `json:"source"`
+	Text         string    `json:"text"`
+	ReceivedAt   time.Time `json:"received_at"`
+	TraceID      string    `json:"trace_id,omitempty"`
+	IngestRegion string    `json:"ingest_region,omitempty"`
+	AccountID    string    `json:"account_id,omitempty"`
+	AmznTraceID  string    `json:"amzn_trace_id,omitempty"`
+	Priority     string    `json:"priority,omitempty"`
+	// BodyRef points at the text in S3 when it was too large to enqueue;
+	// Text is then empty.
+	BodyRef *BodyRef `json:"body_ref,omitempty"`
+	// Reprocess makes the worker overwrite an existing record instead of
+	// treating it as a duplicate. Only replay tooling sets it; ingest never does.
+	Reprocess bool `json:"reprocess,omitempty"`
+}
+
+// Canonical returns the message as canonical JSON: keys sorted, no insignificant
+// whitespace, and ReceivedAt in UTC, so equivalent messages serialize to identical
+// bytes regardless of which ingest format produced them.
+func (m InternalMessage) Canonical() ([]byte, error) {
+	m.ReceivedAt = m.ReceivedAt.UTC()
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	// Round-tripping through a map sorts keys; UseNumber keeps numbers verbatim.
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// BodyRef locates an offloaded message text.
+type BodyRef struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// Priority hints accepted in the X-Priority header.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// recordNamespace scopes RecordID so its UUIDs cannot collide with other
+// name-based UUIDs.
+var recordNamespace = uuid.MustParse("2c689180-0bcd-48cd-85ef-e09b5693eb97")
+
+// RecordID returns a UUIDv5 derived from the tenant, log ID, and a hash of
+// the text, so the same record always maps to the same ID.
+func (m InternalMessage) RecordID() string {
+	contentHash := sha256.Sum256([]byte(m.Text))
+	name := m.TenantID + "\x00" + m.LogID + "\x00" + hex.EncodeToString(contentHash[:])
+	return uuid.NewSHA1(recordNamespace, []byte(name)).String()
 }
 
 // EnqueueResponse is returned after enqueueing a message.
@@ -25,6 +122,46 @@ type EnqueueResponse struct {
 	Status   string `json:"status"`
 	TenantID string `json:"tenant_id"`
 	LogID    string `json:"log_id"`
+	TraceID  string `json:"trace_id,omitempty"`
+	// RedactionRules lists the rules the text matches when ?explain=true is set.
+	RedactionRules []string `json:"redaction_rules,omitempty"`
+}
+
+// ProcessedResponse is returned by synchronous ingest with the stored record.
+// The original text is omitted so the response never echoes unredacted data.
+type ProcessedResponse struct {
+	Status       string `json:"status"`
+	TenantID     string `json:"tenant_id"`
+	LogID        string `json:"log_id"`
+	TraceID      string `json:"trace_id,omitempty"`
+	Source       string `json:"source"`
+	ModifiedData string `json:"modified_data"`
+	ProcessedAt  string `json:"processed_at,omitempty"`
+	// RedactionRules lists the rules the text matches when ?explain=true is set.
+	RedactionRules []string `json:"redaction_rules,omitempty"`
+}
+
+// BatchResponse is returned for NDJSON batches, reporting how many lines were
+// enqueued and which were not.
+type BatchResponse struct {
+	Status   string      `json:"status"`
+	Enqueued int         `json:"enqueued"`
+	Failed   []LineError `json:"failed"`
+}
+
+// LineError describes a batch line that was not enqueued. Lines are 1-based.
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ResponseVersion is the current version of ResponseEnvelope.
+const ResponseVersion = 1
+
+// ResponseEnvelope wraps a response body with its schema version.
+type ResponseEnvelope struct {
+	Version int `json:"version"`
+	Data    any `json:"data"`
 }
 
 // NewInternalMessage builds a normalized message with a UTC timestamp.
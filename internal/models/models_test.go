@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCanonical(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	message := InternalMessage{TenantID: "acme", LogID: "log-1", Source: "json_upload", Text: "hello", ReceivedAt: at, Priority: PriorityHigh}
+
+	got, err := message.Canonical()
+	if err != nil {
+		t.Fatalf("Canonical: %v", err)
+	}
+	want := `{"log_id":"log-1","priority":"high","received_at":"2026-03-01T12:00:00Z","source":"json_upload","tenant_id":"acme","text":"hello"}`
+	if string(got) != want {
+		t.Errorf("Canonical = %s, want %s", got, want)
+	}
+
+	// The same instant in another zone serializes identically.
+	message.ReceivedAt = at.In(time.FixedZone("UTC+9", 9*60*60))
+	if other, _ := message.Canonical(); string(other) != want {
+		t.Errorf("Canonical with a non-UTC received_at = %s, want %s", other, want)
+	}
+}
+
+func TestRecordID(t *testing.T) {
+	base := NewInternalMessage("acme", "log-1", "json_upload", "hello")
+	id := base.RecordID()
+	if _, err := uuid.Parse(id); err != nil {
+		t.Fatalf("RecordID = %q, not a UUID: %v", id, err)
+	}
+	if version := uuid.MustParse(id).Version(); version != 5 {
+		t.Errorf("RecordID version = %d, want 5", version)
+	}
+
+	// Only tenant, log ID and text feed the ID.
+	same := base
+	same.Source, same.TraceID, same.ReceivedAt = "text_upload", "trace-2", base.ReceivedAt.Add(time.Hour)
+	if got := same.RecordID(); got != id {
+		t.Errorf("RecordID changed with non-identifying fields: %s, want %s", got, id)
+	}
+
+	seen := map[string]string{id: "base"}
+	for name, message := range map[string]InternalMessage{
+		"other tenant": NewInternalMessage("globex", "log-1", "json_upload", "hello"),
+		"other log_id": NewInternalMessage("acme", "log-2", "json_upload", "hello"),
+		"other text":   NewInternalMessage("acme", "log-1", "json_upload", "hello!"),
+		// The separator keeps shifted boundaries from colliding.
+		"shifted boundary": NewInternalMessage("acm", "elog-1", "json_upload", "hello"),
+	} {
+		got := message.RecordID()
+		if previous, ok := seen[got]; ok {
+			t.Errorf("%s RecordID %s collides with %s", name, got, previous)
+		}
+		seen[got] = name
+	}
+}
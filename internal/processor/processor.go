@@ -0,0 +1,326 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abadojack/whatlanggo"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/metrics"
+	"memory-machine/internal/models"
+	"memory-machine/internal/redaction"
+)
+
+// itemSchemaVersion is stored on every item so readers can tell attribute
+// layouts apart. Items written before it was introduced have none; treat them
+// as version 0.
+const itemSchemaVersion = 1
+
+// SchemaVersion returns the version item was written with, so readers can
+// migrate older layouts: 0 for items that predate schema_version.
+func SchemaVersion(item map[string]types.AttributeValue) int {
+	n, ok := item["schema_version"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	version, err := strconv.Atoi(n.Value)
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// now is Process's clock; tests can replace it.
+var now = time.Now
+
+var (
+	// ErrEmptyText reports an empty text when EMPTY_TEXT_MODE is fail.
+	ErrEmptyText = errors.New("empty text")
+	// ErrRedactionLeak reports redacted output that still matches a rule.
+	ErrRedactionLeak = errors.New("redacted output still matches rules")
+	// ErrWritePacing reports a context that ended while waiting for the
+	// tenant's write rate.
+	ErrWritePacing = errors.New("write pacing")
+)
+
+// PutItemAPI is the subset of the DynamoDB client used to persist items.
+type PutItemAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// Result describes a processed message.
+type Result struct {
+	// Item is the item written, or the one that would have been for a duplicate.
+	Item        map[string]types.AttributeValue
+	Redacted    string
+	ProcessedAt string
+	// Duplicate is set when the conditional write found an existing item.
+	Duplicate bool
+	// Skipped is set when an empty text was dropped without a write.
+	Skipped bool
+	// RolledUp is set when only the tenant's rollup counters were updated.
+	RolledUp bool
+}
+
+// Process transforms and redacts message and writes it to the tenant logs
+// table with a conditional insert, so a log_id is persisted at most once
+// unless the message is a reprocess. With ROLLUP_TABLE_NAME set, first writes
+// also bump the tenant's hourly counters, and ROLLUP_ONLY_TENANTS records update
+// only the counters, leaving a text-free marker that dedups redeliveries.
+// Neither a duplicate nor a skipped empty text is an error.
+func Process(ctx context.Context, db DynamoDBAPI, settings config.Settings, message models.InternalMessage) (Result, error) {
+	start := now()
+	if message.Text == "" {
+		switch settings.EmptyTextMode {
+		case config.EmptyTextSkip:
+			logging.Message(message).Info("skipped empty text")
+			return Result{Skipped: true}, nil
+		case config.EmptyTextFail:
+			return Result{}, ErrEmptyText
+		}
+	}
+
+	text := message.Text
+	if settings.Transform != nil {
+		transformed, err := settings.Transform.Apply(ctx, message)
+		if err != nil {
+			return Result{}, fmt.Errorf("transform tenant_id=%s log_id=%s: %w", message.TenantID, message.LogID, err)
+		}
+		text = transformed
+	}
+
+	redactionResult := redaction.Apply(text, settings.RedactionRules)
+	redacted := redactionResult.Text
+	if settings.RedactionDebug {
+		logRedactionSpans(message, redactionResult)
+	}
+	if settings.VerifyRedaction {
+		if leaked := redaction.Leaks(redacted, settings.RedactionRules); len(leaked) > 0 {
+			logging.Message(message).Error("ALERT redaction leak", "rules", strings.Join(leaked, ","))
+			return Result{}, fmt.Errorf("%w %s", ErrRedactionLeak, strings.Join(leaked, ","))
+		}
+	}
+	processedTime := now().UTC()
+	processedAt := processedTime.Format(time.RFC3339)
+	dayBucket := processedTime.In(settings.DayBucketLocation).Format("2006-01-02")
+
+	item := map[string]types.AttributeValue{
+		"tenant_id":      &types.AttributeValueMemberS{Value: message.TenantID},
+		"log_id":         &types.AttributeValueMemberS{Value: message.LogID},
+		"source":         &types.AttributeValueMemberS{Value: message.Source},
+		"original_text":  &types.AttributeValueMemberS{Value: message.Text},
+		"modified_data":  &types.AttributeValueMemberS{Value: redacted},
+		"processed_at":   &types.AttributeValueMemberS{Value: processedAt},
+		"day_bucket":     &types.AttributeValueMemberS{Value: dayBucket},
+		"schema_version": &types.AttributeValueMemberN{Value: strconv.Itoa(itemSchemaVersion)},
+	}
+	// Messages enqueued before received_at existed carry the zero time.
+	if !message.ReceivedAt.IsZero() {
+		item["received_at"] = &types.AttributeValueMemberS{Value: message.ReceivedAt.UTC().Format(time.RFC3339)}
+	}
+	if settings.RedactionAudit != "" && len(redactionResult.Matches) > 0 {
+		item["redaction_audit"] = redactionAudit(redactionResult, settings)
+	}
+	if settings.EnrichTextStats {
+		// Rune and Unicode-whitespace counts keep multibyte text accurate.
+		item["text_length"] = &types.AttributeValueMemberN{Value: strconv.Itoa(utf8.RuneCountInString(message.Text))}
+		item["word_count"] = &types.AttributeValueMemberN{Value: strconv.Itoa(len(strings.Fields(message.Text)))}
+	}
+	if settings.RecordTTLDays > 0 {
+		expiresAt := processedTime.AddDate(0, 0, settings.RecordTTLDays)
+		item["expires_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)}
+	}
+	if settings.FlagRedaction {
+		item["redaction_applied"] = &types.AttributeValueMemberBOOL{Value: len(redactionResult.Matches) > 0}
+	}
+	if settings.EnrichRecordID {
+		item["record_id"] = &types.AttributeValueMemberS{Value: message.RecordID()}
+	}
+	if settings.DetectLanguage {
+		if lang := detectLanguage(message.Text); lang != "" {
+			item["lang"] = &types.AttributeValueMemberS{Value: lang}
+		}
+	}
+	if settings.StoreLatency {
+		if !message.ReceivedAt.IsZero() {
+			item["queue_latency_ms"] = latencyValue(processedTime.Sub(message.ReceivedAt))
+		}
+		item["processing_latency_ms"] = latencyValue(processedTime.Sub(startedAt(ctx, start)))
+	}
+	if message.IngestRegion != "" {
+		item["ingest_region"] = &types.AttributeValueMemberS{Value: message.IngestRegion}
+	}
+	if message.AccountID != "" {
+		item["account_id"] = &types.AttributeValueMemberS{Value: message.AccountID}
+	}
+	result := Result{Item: item, Redacted: redacted, ProcessedAt: processedAt}
+
+	if rate, ok := settings.TenantWriteRates[message.TenantID]; ok {
+		if err := writeLimiter.Wait(ctx, message.TenantID, rate); err != nil {
+			return Result{}, fmt.Errorf("%w: %w", ErrWritePacing, err)
+		}
+	}
+
+	redactions := redactionCount(redactionResult)
+	if settings.RollupOnlyTenants[message.TenantID] {
+		duplicate, err := rollupOnce(ctx, db, settings, message, rollupMarker(item), processedTime, redactions)
+		if err != nil {
+			return Result{}, err
+		}
+		if duplicate {
+			logging.Message(message).Info("duplicate detected", "amzn_trace_id", message.AmznTraceID)
+			metrics.Count("DuplicatesDetected", 1, map[string]string{"TenantID": message.TenantID})
+			result.Duplicate = true
+			return result, nil
+		}
+		logging.Message(message).Info("rolled up", "processed_at", processedAt)
+		countProcessed(message, redactions)
+		result.RolledUp = true
+		return result, nil
+	}
+
+	err := putWithRetry(ctx, db, settings, message, &dynamodb.PutItemInput{
+		TableName:           stringPtr(settings.DynamoDBTableName),
+		Item:                item,
+		ConditionExpression: InsertCondition(message),
+	})
+	if err != nil {
+		var cfe *types.ConditionalCheckFailedException
+		if errors.As(err, &cfe) {
+			logging.Message(message).Info("duplicate detected", "amzn_trace_id", message.AmznTraceID)
+			metrics.Count("DuplicatesDetected", 1, map[string]string{"TenantID": message.TenantID})
+			result.Duplicate = true
+			return result, nil
+		}
+		return Result{}, fmt.Errorf("dynamodb put error: %w", err)
+	}
+
+	if message.Reprocess {
+		logging.Message(message).Info("reprocess overwrite")
+	}
+	logging.Message(message).Info("persisted", "amzn_trace_id", message.AmznTraceID, "processed_at", processedAt)
+	countProcessed(message, redactions)
+
+	// Counting only first writes means a record is never counted twice:
+	// redeliveries stop at the conditional write, and a reprocess was already
+	// counted. The update is best-effort, so a failure here undercounts.
+	if settings.RollupTableName != "" && !message.Reprocess {
+		if err := incrementRollup(ctx, db, settings, message, processedTime, redactions); err != nil {
+			logging.Message(message).Warn("rollup update failed", "error", err)
+		}
+	}
+	return result, nil
+}
+
+// countProcessed emits the per-tenant metrics for a processed record.
+func countProcessed(message models.InternalMessage, redactions int) {
+	tenantDimension := map[string]string{"TenantID": message.TenantID}
+	metrics.Count("RecordsProcessed", 1, tenantDimension)
+	metrics.Count("RedactionsApplied", float64(redactions), tenantDimension)
+}
+
+// startKey is the context key for WithStart.
+type startKey struct{}
+
+// WithStart records when work on a message began, so processing_latency_ms
+// covers steps before Process such as decrypting and loading the body.
+func WithStart(ctx context.Context, start time.Time) context.Context {
+	return context.WithValue(ctx, startKey{}, start)
+}
+
+// startedAt returns the time set by WithStart, or fallback.
+func startedAt(ctx context.Context, fallback time.Time) time.Time {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		return start
+	}
+	return fallback
+}
+
+// latencyValue stores d in whole milliseconds; clock skew between Lambdas can
+// make it negative, which is stored as 0.
+func latencyValue(d time.Duration) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(max(0, d.Milliseconds()), 10)}
+}
+
+// InsertCondition makes the PutItem a conditional insert so redelivered messages
+// dedup. Reprocessed messages have no condition and overwrite the existing item.
+func InsertCondition(message models.InternalMessage) *string {
+	if message.Reprocess {
+		return nil
+	}
+	return stringPtr("attribute_not_exists(tenant_id) AND attribute_not_exists(log_id)")
+}
+
+// redactionAudit summarizes what each rule matched without storing the raw values:
+// a count per rule and, in hashes mode, a keyed hash of every matched value.
+func redactionAudit(result redaction.Result, settings config.Settings) types.AttributeValue {
+	rules := make(map[string]types.AttributeValue, len(result.Matches))
+	for _, match := range result.Matches {
+		entry := map[string]types.AttributeValue{
+			"count": &types.AttributeValueMemberN{Value: strconv.Itoa(len(match.Values))},
+		}
+		if settings.RedactionAudit == config.RedactionAuditHashes {
+			hashes := make([]types.AttributeValue, 0, len(match.Values))
+			for _, value := range match.Values {
+				hashes = append(hashes, &types.AttributeValueMemberS{Value: redaction.HashValue(settings.RedactionHashKey, value)})
+			}
+			entry["hashes"] = &types.AttributeValueMemberL{Value: hashes}
+		}
+		rules[match.Rule] = &types.AttributeValueMemberM{Value: entry}
+	}
+	return &types.AttributeValueMemberM{Value: rules}
+}
+
+// minLanguageRunes is the shortest text worth running language detection on;
+// shorter snippets are too ambiguous to classify.
+const minLanguageRunes = 20
+
+// detectLanguage returns the ISO 639-1 code (639-3 where no 639-1 code exists)
+// of text, or "" when the text is short or the detection is unreliable.
+func detectLanguage(text string) string {
+	if utf8.RuneCountInString(text) < minLanguageRunes {
+		return ""
+	}
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return ""
+	}
+	if code := info.Lang.Iso6391(); code != "" {
+		return code
+	}
+	return info.Lang.Iso6393()
+}
+
+// redactionCount is the number of values replaced across all rules.
+func redactionCount(result redaction.Result) int {
+	count := 0
+	for _, match := range result.Matches {
+		count += len(match.Values)
+	}
+	return count
+}
+
+// logRedactionSpans logs which rule replaced which byte range, without the
+// matched values, so rules can be debugged from logs safely.
+func logRedactionSpans(message models.InternalMessage, result redaction.Result) {
+	var spans []string
+	for _, match := range result.Matches {
+		for _, span := range match.Spans {
+			spans = append(spans, fmt.Sprintf("%s[%d:%d]", match.Rule, span[0], span[1]))
+		}
+	}
+	logging.Message(message).Info("redaction debug", "spans", strings.Join(spans, ","))
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
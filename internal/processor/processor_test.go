@@ -0,0 +1,749 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/models"
+	"memory-machine/internal/redaction"
+)
+
+// fakeDB is a DynamoDBAPI that records every call. PutItem returns putErrs in
+// order, then nil; UpdateItem returns updateErr. TransactWriteItems returns
+// transactErr, or cancels a transaction whose marker log_id it has already
+// committed.
+type fakeDB struct {
+	puts         []*dynamodb.PutItemInput
+	updates      []*dynamodb.UpdateItemInput
+	transactions []*dynamodb.TransactWriteItemsInput
+	committed    []*dynamodb.TransactWriteItemsInput
+	putErrs      []error
+	updateErr    error
+	transactErr  error
+}
+
+func (f *fakeDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts = append(f.puts, params)
+	if len(f.putErrs) > 0 {
+		err := f.putErrs[0]
+		f.putErrs = f.putErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.updates = append(f.updates, params)
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.transactions = append(f.transactions, params)
+	if f.transactErr != nil {
+		return nil, f.transactErr
+	}
+	logID := stringAttr(params.TransactItems[0].Put.Item, "log_id")
+	for _, committed := range f.committed {
+		if stringAttr(committed.TransactItems[0].Put.Item, "log_id") == logID {
+			return nil, &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+				{Code: aws.String("ConditionalCheckFailed")},
+				{Code: aws.String("None")},
+			}}
+		}
+	}
+	f.committed = append(f.committed, params)
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// testSettings returns the settings Load produces with no optional variables set.
+func testSettings() config.Settings {
+	return config.Settings{
+		DynamoDBTableName: "tenant-logs",
+		DayBucketLocation: time.UTC,
+		EmptyTextMode:     config.EmptyTextStore,
+		PutMaxAttempts:    1,
+		RedactionRules:    redaction.DefaultRules,
+	}
+}
+
+func testMessage() models.InternalMessage {
+	return models.NewInternalMessage("acme", "log-1", "json_upload", "call 555-123-4567")
+}
+
+// stringAttr returns the string value of a stored attribute, or "".
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+// numberAttr returns the number value of a stored attribute, or "".
+func numberAttr(item map[string]types.AttributeValue, name string) string {
+	if n, ok := item[name].(*types.AttributeValueMemberN); ok {
+		return n.Value
+	}
+	return ""
+}
+
+// processOne runs Process against a fresh fakeDB and fails the test on error.
+func processOne(t *testing.T, settings config.Settings, message models.InternalMessage) (Result, *fakeDB) {
+	t.Helper()
+	db := &fakeDB{}
+	result, err := Process(context.Background(), db, settings, message)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	return result, db
+}
+
+// setNow fixes Process's clock at t for the rest of the test.
+func setNow(t *testing.T, at time.Time) {
+	t.Helper()
+	previous := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = previous })
+}
+
+func TestProcessDayBucket(t *testing.T) {
+	// 23:30 UTC on March 1 is already March 2 at UTC+14 and still March 1 at
+	// UTC-11; 00:30 UTC on March 2 is still March 1 at UTC-11.
+	lateEvening := time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC)
+	afterMidnight := time.Date(2026, 3, 2, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		timezone string
+		at       time.Time
+		want     string
+	}{
+		{"default UTC", "UTC", lateEvening, "2026-03-01"},
+		{"ahead of UTC", "Pacific/Kiritimati", lateEvening, "2026-03-02"},
+		{"behind UTC", "Pacific/Pago_Pago", lateEvening, "2026-03-01"},
+		{"UTC after midnight", "UTC", afterMidnight, "2026-03-02"},
+		{"behind UTC after midnight", "Pacific/Pago_Pago", afterMidnight, "2026-03-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := time.LoadLocation(tt.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			settings := testSettings()
+			settings.DayBucketLocation = location
+			setNow(t, tt.at)
+
+			_, db := processOne(t, settings, testMessage())
+			item := db.puts[0].Item
+			if got := stringAttr(item, "day_bucket"); got != tt.want {
+				t.Errorf("day_bucket = %q, want %q", got, tt.want)
+			}
+			if got, want := stringAttr(item, "processed_at"), tt.at.Format(time.RFC3339); got != want {
+				t.Errorf("processed_at = %q, want %q in UTC", got, want)
+			}
+		})
+	}
+}
+
+func TestProcessProvenance(t *testing.T) {
+	message := testMessage()
+	_, db := processOne(t, testSettings(), message)
+	if _, ok := db.puts[0].Item["ingest_region"]; ok {
+		t.Error("ingest_region stored for a message without provenance")
+	}
+
+	message.IngestRegion, message.AccountID = "eu-west-1", "123456789012"
+	_, db = processOne(t, testSettings(), message)
+	item := db.puts[0].Item
+	if got := stringAttr(item, "ingest_region"); got != "eu-west-1" {
+		t.Errorf("ingest_region = %q, want eu-west-1", got)
+	}
+	if got := stringAttr(item, "account_id"); got != "123456789012" {
+		t.Errorf("account_id = %q, want 123456789012", got)
+	}
+}
+
+func TestProcessRedactionAudit(t *testing.T) {
+	text := "mail a@example.com or b@example.com, call 555-123-4567"
+	tests := []struct {
+		name       string
+		mode       string
+		wantHashes bool
+	}{
+		{"counts", config.RedactionAuditCounts, false},
+		{"hashes", config.RedactionAuditHashes, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.RedactionAudit = tt.mode
+			settings.RedactionHashKey = []byte("audit-key")
+			message := testMessage()
+			message.Text = text
+
+			_, db := processOne(t, settings, message)
+			audit, ok := db.puts[0].Item["redaction_audit"].(*types.AttributeValueMemberM)
+			if !ok {
+				t.Fatalf("redaction_audit = %#v, want a map", db.puts[0].Item["redaction_audit"])
+			}
+			wantCounts := map[string]string{"email": "2", "phone": "1"}
+			if len(audit.Value) != len(wantCounts) {
+				t.Fatalf("audited rules = %v, want %v", audit.Value, wantCounts)
+			}
+			for rule, wantCount := range wantCounts {
+				entry, ok := audit.Value[rule].(*types.AttributeValueMemberM)
+				if !ok {
+					t.Fatalf("no audit entry for %s", rule)
+				}
+				if got := numberAttr(entry.Value, "count"); got != wantCount {
+					t.Errorf("%s count = %s, want %s", rule, got, wantCount)
+				}
+				hashes, hasHashes := entry.Value["hashes"].(*types.AttributeValueMemberL)
+				if hasHashes != tt.wantHashes {
+					t.Fatalf("%s hashes present = %v, want %v", rule, hasHashes, tt.wantHashes)
+				}
+				if hasHashes && strconv.Itoa(len(hashes.Value)) != wantCount {
+					t.Errorf("%s has %d hashes, want %s", rule, len(hashes.Value), wantCount)
+				}
+			}
+			if tt.wantHashes {
+				email := audit.Value["email"].(*types.AttributeValueMemberM).Value["hashes"].(*types.AttributeValueMemberL)
+				want := redaction.HashValue(settings.RedactionHashKey, "a@example.com")
+				if got := email.Value[0].(*types.AttributeValueMemberS).Value; got != want {
+					t.Errorf("first email hash = %s, want %s", got, want)
+				}
+				if strings.Contains(fmt.Sprint(audit.Value), "example.com") {
+					t.Error("audit stores a raw matched value")
+				}
+			}
+		})
+	}
+}
+
+func TestProcessRedactionAuditOmittedWithoutMatches(t *testing.T) {
+	settings := testSettings()
+	settings.RedactionAudit = config.RedactionAuditCounts
+	message := testMessage()
+	message.Text = "nothing sensitive"
+
+	_, db := processOne(t, settings, message)
+	if _, ok := db.puts[0].Item["redaction_audit"]; ok {
+		t.Error("redaction_audit stored for a text with no matches")
+	}
+}
+
+func TestProcessReprocess(t *testing.T) {
+	tests := []struct {
+		name          string
+		reprocess     bool
+		wantCondition bool
+	}{
+		{"normal insert is conditional", false, true},
+		{"reprocess overwrites", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := testMessage()
+			message.Reprocess = tt.reprocess
+			_, db := processOne(t, testSettings(), message)
+			if got := db.puts[0].ConditionExpression != nil; got != tt.wantCondition {
+				t.Errorf("condition present = %v, want %v", got, tt.wantCondition)
+			}
+		})
+	}
+}
+
+func TestProcessRedactionDebug(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	settings := testSettings()
+	settings.RedactionDebug = true
+	message := testMessage()
+	message.Text = "mail a@b.io or c@d.io, call 555-1234"
+	processOne(t, settings, message)
+
+	var record map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var logged map[string]any
+		if json.Unmarshal([]byte(line), &logged) == nil && logged["msg"] == "redaction debug" {
+			record = logged
+		}
+	}
+	if record == nil {
+		t.Fatalf("no redaction debug log in %s", buf.String())
+	}
+	if got, want := record["spans"], "email[5:11],email[15:21],phone[36:44]"; got != want {
+		t.Errorf("spans = %v, want %v", got, want)
+	}
+	if strings.Contains(buf.String(), "a@b.io") || strings.Contains(buf.String(), "555-1234") {
+		t.Error("debug log contains a raw matched value")
+	}
+}
+
+func TestProcessTextStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantLength string
+		wantWords  string
+	}{
+		{"ascii", "hello there world", "17", "3"},
+		{"multibyte", "héllo wörld 日本語", "15", "3"},
+		{"unicode whitespace", "one two　three", "13", "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.EnrichTextStats = true
+			message := testMessage()
+			message.Text = tt.text
+
+			_, db := processOne(t, settings, message)
+			item := db.puts[0].Item
+			if got := numberAttr(item, "text_length"); got != tt.wantLength {
+				t.Errorf("text_length = %s, want %s", got, tt.wantLength)
+			}
+			if got := numberAttr(item, "word_count"); got != tt.wantWords {
+				t.Errorf("word_count = %s, want %s", got, tt.wantWords)
+			}
+		})
+	}
+}
+
+func TestProcessTextStatsDisabled(t *testing.T) {
+	_, db := processOne(t, testSettings(), testMessage())
+	if _, ok := db.puts[0].Item["text_length"]; ok {
+		t.Error("text_length stored without ENRICH_TEXT_STATS")
+	}
+}
+
+func TestProcessVerifyRedaction(t *testing.T) {
+	// leaky matches phone numbers but "replaces" them with themselves.
+	leaky := redaction.Rule{Name: "leaky", Pattern: regexp.MustCompile(`\d{3}-\d{4}`), Replace: func(value string) string { return value }}
+	tests := []struct {
+		name     string
+		rules    []redaction.Rule
+		verify   bool
+		wantLeak bool
+	}{
+		{"leaky rule is blocked", []redaction.Rule{leaky}, true, true},
+		{"leaky rule stored without verification", []redaction.Rule{leaky}, false, false},
+		{"working rules pass verification", redaction.DefaultRules, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.RedactionRules = tt.rules
+			settings.VerifyRedaction = tt.verify
+			db := &fakeDB{}
+
+			_, err := Process(context.Background(), db, settings, testMessage())
+			if got := errors.Is(err, ErrRedactionLeak); got != tt.wantLeak {
+				t.Fatalf("Process error = %v, want leak %v", err, tt.wantLeak)
+			}
+			if tt.wantLeak && len(db.puts) != 0 {
+				t.Error("leaked text was stored")
+			}
+			if !tt.wantLeak && len(db.puts) != 1 {
+				t.Errorf("puts = %d, want 1", len(db.puts))
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "The quick brown fox jumps over the lazy dog while the farmer watches from the porch.", "en"},
+		{"spanish", "El rápido zorro marrón salta sobre el perro perezoso mientras el granjero mira desde el porche.", "es"},
+		{"too short", "hello there", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLanguage(tt.text); got != tt.want {
+				t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessStoresLanguage(t *testing.T) {
+	settings := testSettings()
+	settings.DetectLanguage = true
+	message := testMessage()
+	message.Text = "El rápido zorro marrón salta sobre el perro perezoso mientras el granjero mira desde el porche."
+
+	_, db := processOne(t, settings, message)
+	if got := stringAttr(db.puts[0].Item, "lang"); got != "es" {
+		t.Errorf("lang = %q, want es", got)
+	}
+
+	message.Text = "short"
+	_, db = processOne(t, settings, message)
+	if _, ok := db.puts[0].Item["lang"]; ok {
+		t.Error("lang stored for a short text")
+	}
+}
+
+func TestProcessRecordID(t *testing.T) {
+	settings := testSettings()
+	settings.EnrichRecordID = true
+	message := testMessage()
+
+	_, first := processOne(t, settings, message)
+	_, second := processOne(t, settings, message)
+	id := stringAttr(first.puts[0].Item, "record_id")
+	if id != message.RecordID() {
+		t.Errorf("record_id = %q, want %q", id, message.RecordID())
+	}
+	if got := stringAttr(second.puts[0].Item, "record_id"); got != id {
+		t.Errorf("redelivery stored record_id %q, want %q", got, id)
+	}
+}
+
+func TestProcessAppliesEachPatternInTurn(t *testing.T) {
+	settings := testSettings()
+	settings.RedactionRules = []redaction.Rule{
+		{Name: `\d{3}-\d{2}-\d{4}`, Pattern: regexp.MustCompile(`\d{3}-\d{2}-\d{4}`)},
+		{Name: `secret-\w+`, Pattern: regexp.MustCompile(`secret-\w+`)},
+	}
+	message := testMessage()
+	message.Text = "ssn 123-45-6789 key secret-abc phone 555-1234"
+
+	result, _ := processOne(t, settings, message)
+	// The default phone rule is replaced, not extended.
+	if want := "ssn [REDACTED] key [REDACTED] phone 555-1234"; result.Redacted != want {
+		t.Errorf("Redacted = %q, want %q", result.Redacted, want)
+	}
+}
+
+func TestProcessRedactsEmailsAndPhones(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"multiple emails", "cc a@example.com, b.c+tag@mail.example.org", "cc [REDACTED], [REDACTED]"},
+		{"adjacent punctuation", "(alice@example.com); <bob@example.co.uk>. Done!", "([REDACTED]); <[REDACTED]>. Done!"},
+		{"email and phone", "mail alice@example.com or call 555-1234.", "mail [REDACTED] or call [REDACTED]."},
+		{"digits inside an email", "write 555-1234@example.com", "write [REDACTED]"},
+		{"no matches", "nothing to see here @ all", "nothing to see here @ all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := testMessage()
+			message.Text = tt.text
+			_, db := processOne(t, testSettings(), message)
+			item := db.puts[0].Item
+			if got := stringAttr(item, "modified_data"); got != tt.want {
+				t.Errorf("modified_data = %q, want %q", got, tt.want)
+			}
+			if got := stringAttr(item, "original_text"); got != tt.text {
+				t.Errorf("original_text = %q, want the unredacted %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestProcessRedactionApplied(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"with matches", "call 555-1234", true},
+		{"without matches", "nothing sensitive", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.FlagRedaction = true
+			message := testMessage()
+			message.Text = tt.text
+
+			_, db := processOne(t, settings, message)
+			flag, ok := db.puts[0].Item["redaction_applied"].(*types.AttributeValueMemberBOOL)
+			if !ok {
+				t.Fatalf("redaction_applied = %#v, want a boolean", db.puts[0].Item["redaction_applied"])
+			}
+			if flag.Value != tt.want {
+				t.Errorf("redaction_applied = %v, want %v", flag.Value, tt.want)
+			}
+		})
+	}
+
+	_, db := processOne(t, testSettings(), testMessage())
+	if _, ok := db.puts[0].Item["redaction_applied"]; ok {
+		t.Error("redaction_applied stored without FLAG_REDACTION")
+	}
+}
+
+func TestProcessRecordTTL(t *testing.T) {
+	settings := testSettings()
+	settings.RecordTTLDays = 30
+
+	before := time.Now()
+	_, db := processOne(t, settings, testMessage())
+	after := time.Now()
+
+	raw := numberAttr(db.puts[0].Item, "expires_at")
+	expiresAt, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		t.Fatalf("expires_at = %q, want an epoch number", raw)
+	}
+	low, high := before.AddDate(0, 0, 30).Unix(), after.AddDate(0, 0, 30).Unix()
+	if expiresAt < low || expiresAt > high {
+		t.Errorf("expires_at = %d, want now+30 days in [%d, %d]", expiresAt, low, high)
+	}
+
+	_, db = processOne(t, testSettings(), testMessage())
+	if _, ok := db.puts[0].Item["expires_at"]; ok {
+		t.Error("expires_at stored without RECORD_TTL_DAYS")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	_, db := processOne(t, testSettings(), testMessage())
+	if got := SchemaVersion(db.puts[0].Item); got != itemSchemaVersion {
+		t.Errorf("written item has schema version %d, want %d", got, itemSchemaVersion)
+	}
+
+	// An item written before schema_version existed.
+	old := map[string]types.AttributeValue{
+		"tenant_id":     &types.AttributeValueMemberS{Value: "acme"},
+		"log_id":        &types.AttributeValueMemberS{Value: "log-old"},
+		"modified_data": &types.AttributeValueMemberS{Value: "call [REDACTED]"},
+	}
+	if got := SchemaVersion(old); got != 0 {
+		t.Errorf("item without schema_version has version %d, want 0", got)
+	}
+}
+
+func TestProcessEmptyText(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantPuts    int
+		wantSkipped bool
+		wantErr     error
+	}{
+		{config.EmptyTextStore, 1, false, nil},
+		{config.EmptyTextSkip, 0, true, nil},
+		{config.EmptyTextFail, 0, false, ErrEmptyText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			settings := testSettings()
+			settings.EmptyTextMode = tt.mode
+			message := testMessage()
+			message.Text = ""
+
+			db := &fakeDB{}
+			result, err := Process(context.Background(), db, settings, message)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Process error = %v, want %v", err, tt.wantErr)
+			}
+			if result.Skipped != tt.wantSkipped || len(db.puts) != tt.wantPuts {
+				t.Fatalf("skipped = %v with %d puts, want %v with %d", result.Skipped, len(db.puts), tt.wantSkipped, tt.wantPuts)
+			}
+			if tt.wantPuts == 1 {
+				item := db.puts[0].Item
+				if stringAttr(item, "original_text") != "" || stringAttr(item, "modified_data") != "" {
+					t.Errorf("stored %v, want empty original and modified text", item)
+				}
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	ssn := []redaction.Rule{{Name: "ssn", Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)}}
+	tests := []struct {
+		name          string
+		text          string
+		rules         []redaction.Rule
+		putErr        error
+		wantRedacted  string
+		wantDuplicate bool
+		wantErr       string
+	}{
+		{name: "phone", text: "call 555-1234", wantRedacted: "call [REDACTED]"},
+		{name: "email and phone", text: "mail jo@example.com or 555-1234", wantRedacted: "mail [REDACTED] or [REDACTED]"},
+		{name: "nothing to redact", text: "hello world", wantRedacted: "hello world"},
+		{name: "custom rules replace the defaults", text: "ssn 123-45-6789, call 555-1234", rules: ssn, wantRedacted: "ssn [REDACTED], call 555-1234"},
+		{name: "duplicate", text: "call 555-1234", putErr: &types.ConditionalCheckFailedException{}, wantRedacted: "call [REDACTED]", wantDuplicate: true},
+		{name: "put error", text: "call 555-1234", putErr: errors.New("InternalServerError"), wantErr: "dynamodb put error: InternalServerError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			if tt.rules != nil {
+				settings.RedactionRules = tt.rules
+			}
+			message := models.NewInternalMessage("acme", "log-1", "json_upload", tt.text)
+			db := &fakeDB{putErrs: []error{tt.putErr}}
+
+			result, err := Process(context.Background(), db, settings, message)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Process error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if result.Redacted != tt.wantRedacted || result.Duplicate != tt.wantDuplicate {
+				t.Errorf("redacted %q, duplicate %v; want %q, %v", result.Redacted, result.Duplicate, tt.wantRedacted, tt.wantDuplicate)
+			}
+			if len(db.puts) != 1 {
+				t.Fatalf("puts = %d, want 1", len(db.puts))
+			}
+			put := db.puts[0]
+			if *put.TableName != "tenant-logs" {
+				t.Errorf("table = %s, want tenant-logs", *put.TableName)
+			}
+			want := map[string]string{
+				"tenant_id":     "acme",
+				"log_id":        "log-1",
+				"source":        "json_upload",
+				"original_text": tt.text,
+				"modified_data": tt.wantRedacted,
+				"processed_at":  result.ProcessedAt,
+			}
+			for name, value := range want {
+				if got := stringAttr(put.Item, name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+			if numberAttr(put.Item, "schema_version") != strconv.Itoa(itemSchemaVersion) {
+				t.Errorf("schema_version = %q, want %d", numberAttr(put.Item, "schema_version"), itemSchemaVersion)
+			}
+		})
+	}
+}
+
+// The production client satisfies the interface Process accepts.
+var _ DynamoDBAPI = (*dynamodb.Client)(nil)
+
+func TestProcessConditionalWrite(t *testing.T) {
+	const insertOnly = "attribute_not_exists(tenant_id) AND attribute_not_exists(log_id)"
+	tests := []struct {
+		name          string
+		reprocess     bool
+		putErr        error
+		wantCondition string
+		wantDuplicate bool
+	}{
+		{"insert", false, nil, insertOnly, false},
+		{"duplicate", false, &types.ConditionalCheckFailedException{Message: stringPtr("The conditional request failed")}, insertOnly, true},
+		{"reprocess drops the condition", true, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := testMessage()
+			message.Reprocess = tt.reprocess
+			db := &fakeDB{putErrs: []error{tt.putErr}}
+
+			result, err := Process(context.Background(), db, testSettings(), message)
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if len(db.puts) != 1 {
+				t.Fatalf("PutItem calls = %d, want 1", len(db.puts))
+			}
+			put := db.puts[0]
+			if got := aws.ToString(put.ConditionExpression); got != tt.wantCondition {
+				t.Errorf("ConditionExpression = %q, want %q", got, tt.wantCondition)
+			}
+			if result.Duplicate != tt.wantDuplicate {
+				t.Errorf("Duplicate = %v, want %v", result.Duplicate, tt.wantDuplicate)
+			}
+			if stringAttr(result.Item, "log_id") != "log-1" || stringAttr(put.Item, "log_id") != "log-1" {
+				t.Error("result and put do not carry the item for log-1")
+			}
+		})
+	}
+}
+
+func TestProcessStoresLatency(t *testing.T) {
+	// latency parses a stored millisecond attribute, or -1 when absent.
+	latency := func(item map[string]types.AttributeValue, name string) int64 {
+		raw := numberAttr(item, name)
+		if raw == "" {
+			return -1
+		}
+		ms, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			t.Fatalf("%s = %q, want milliseconds", name, raw)
+		}
+		return ms
+	}
+	const slack = 1000
+	tests := []struct {
+		name        string
+		store       bool
+		receivedAgo time.Duration
+		unset       bool // a message from before received_at existed
+		wantQueue   [2]int64
+	}{
+		{"both latencies", true, 2 * time.Second, false, [2]int64{2000, 2000 + slack}},
+		{"missing received_at", true, 0, true, [2]int64{-1, -1}},
+		{"received_at ahead from clock skew", true, -time.Minute, false, [2]int64{0, 0}},
+		{"disabled", false, 2 * time.Second, false, [2]int64{-1, -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.StoreLatency = tt.store
+			message := testMessage()
+			message.ReceivedAt = time.Now().Add(-tt.receivedAgo)
+			if tt.unset {
+				message.ReceivedAt = time.Time{}
+			}
+			db := &fakeDB{}
+			ctx := WithStart(context.Background(), time.Now().Add(-500*time.Millisecond))
+			if _, err := Process(ctx, db, settings, message); err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			item := db.puts[0].Item
+
+			if got := latency(item, "queue_latency_ms"); got < tt.wantQueue[0] || got > tt.wantQueue[1] {
+				t.Errorf("queue_latency_ms = %d, want within %v", got, tt.wantQueue)
+			}
+			got := latency(item, "processing_latency_ms")
+			if !tt.store {
+				if got != -1 {
+					t.Errorf("processing_latency_ms = %d stored without STORE_LATENCY", got)
+				}
+				return
+			}
+			if got < 500 || got > 500+slack {
+				t.Errorf("processing_latency_ms = %d, want the time since WithStart, about 500", got)
+			}
+		})
+	}
+}
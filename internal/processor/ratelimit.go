@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// writeLimiter paces DynamoDB writes per tenant. It lives for the whole Lambda
+// container so pacing carries across batches.
+var writeLimiter = &tenantLimiter{buckets: make(map[string]*tokenBucket)}
+
+// tokenBucket holds up to max(1, rate) tokens, refilled at rate per second.
+// Tokens may go negative, which queues later callers behind earlier ones.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type tenantLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// reserve takes a token for tenant at time at and returns how long the caller
+// must wait before using it.
+func (l *tenantLimiter) reserve(tenantID string, rate float64, at time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := max(1, rate)
+	bucket, ok := l.buckets[tenantID]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: at}
+		l.buckets[tenantID] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+at.Sub(bucket.last).Seconds()*rate)
+	bucket.last = at
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / rate * float64(time.Second))
+}
+
+// Wait blocks until tenant may write at rate writes per second, or ctx ends.
+func (l *tenantLimiter) Wait(ctx context.Context, tenantID string, rate float64) error {
+	delay := l.reserve(tenantID, rate, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLimiter() *tenantLimiter {
+	return &tenantLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func TestReservePacesHighRateTenant(t *testing.T) {
+	limiter := newTestLimiter()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// A burst of rate writes goes through, then each write waits 1/rate longer.
+	want := []time.Duration{0, 0, 0, 0, 250 * time.Millisecond, 500 * time.Millisecond, 750 * time.Millisecond}
+	for i, wantDelay := range want {
+		if got := limiter.reserve("acme", 4, start); got != wantDelay {
+			t.Errorf("write %d delay = %s, want %s", i, got, wantDelay)
+		}
+	}
+
+	// Another tenant has its own bucket.
+	if got := limiter.reserve("globex", 4, start); got != 0 {
+		t.Errorf("other tenant delay = %s, want 0", got)
+	}
+
+	// Once the queued writes have drained, the burst refills but no further.
+	refilled := start.Add(3 * time.Second)
+	for i := 0; i < 4; i++ {
+		if got := limiter.reserve("acme", 4, refilled); got != 0 {
+			t.Errorf("refilled write %d delay = %s, want 0", i, got)
+		}
+	}
+	if got := limiter.reserve("acme", 4, refilled); got != 250*time.Millisecond {
+		t.Errorf("write past the refilled burst delay = %s, want 250ms", got)
+	}
+}
+
+func TestReserveSlowRateHasBurstOfOne(t *testing.T) {
+	limiter := newTestLimiter()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := limiter.reserve("acme", 0.5, start); got != 0 {
+		t.Errorf("first write delay = %s, want 0", got)
+	}
+	if got := limiter.reserve("acme", 0.5, start); got != 2*time.Second {
+		t.Errorf("second write delay = %s, want 2s", got)
+	}
+}
+
+func TestProcessPacesTenantWrites(t *testing.T) {
+	previous := writeLimiter
+	writeLimiter = newTestLimiter()
+	t.Cleanup(func() { writeLimiter = previous })
+
+	settings := testSettings()
+	settings.TenantWriteRates = map[string]float64{"acme": 1}
+	db := &fakeDB{}
+	if _, err := Process(context.Background(), db, settings, testMessage()); err != nil {
+		t.Fatalf("first Process: %v", err)
+	}
+
+	// The second write must wait a second; a deadline shorter than that ends it unwritten.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Process(ctx, db, settings, testMessage())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("paced Process error = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("paced Process returned after %s, want it to wait for the deadline", elapsed)
+	}
+	if len(db.puts) != 1 {
+		t.Errorf("puts = %d, want only the unpaced write", len(db.puts))
+	}
+
+	// Unlisted tenants are not paced.
+	other := testMessage()
+	other.TenantID = "globex"
+	for i := 0; i < 3; i++ {
+		if _, err := Process(context.Background(), db, settings, other); err != nil {
+			t.Fatalf("unpaced Process: %v", err)
+		}
+	}
+}
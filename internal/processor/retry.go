@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/logging"
+	"memory-machine/internal/metrics"
+	"memory-machine/internal/models"
+)
+
+// NewClient returns a DynamoDB client for Process with the SDK's own retries
+// disabled. Process retries puts itself, so PUT_MAX_ATTEMPTS is then the exact
+// number of attempts.
+func NewClient(cfg aws.Config) *dynamodb.Client {
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// putWithRetry writes input, retrying throttled and transient failures up to
+// settings.PutMaxAttempts times with jittered exponential backoff. Conditional
+// check failures and other errors are returned at once.
+func putWithRetry(ctx context.Context, db PutItemAPI, settings config.Settings, message models.InternalMessage, input *dynamodb.PutItemInput) error {
+	for attempt := 1; ; attempt++ {
+		_, err := db.PutItem(ctx, input)
+		if err == nil || attempt >= settings.PutMaxAttempts || !retryablePut(err) {
+			return err
+		}
+		delay := backoff(settings.PutRetryBase, attempt)
+		logging.Message(message).Warn("retrying dynamodb put", "attempt", attempt, "delay_ms", delay.Milliseconds(), "error", err)
+		metrics.Count("PutRetries", 1, map[string]string{"TenantID": message.TenantID})
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// retryablePut reports whether a failed put is worth another attempt:
+// throttling and the SDK's transient errors, never a failed condition.
+func retryablePut(err error) bool {
+	var cfe *types.ConditionalCheckFailedException
+	if errors.As(err, &cfe) {
+		return false
+	}
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary ||
+		retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// backoff is base doubled for each attempt after the first, with the upper half
+// randomized so concurrent writers spread out.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << (attempt - 1)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
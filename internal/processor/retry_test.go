@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestPutRetriesTransientErrors(t *testing.T) {
+	throttled := &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+	tests := []struct {
+		name          string
+		putErrs       []error
+		maxAttempts   int
+		wantPuts      int
+		wantErr       bool
+		wantDuplicate bool
+	}{
+		{"fails twice then succeeds", []error{throttled, throttled}, 3, 3, false, false},
+		{"gives up after max attempts", []error{throttled, throttled, throttled}, 3, 3, true, false},
+		{"one attempt disables retrying", []error{throttled}, 1, 1, true, false},
+		{"duplicate is not retried", []error{&types.ConditionalCheckFailedException{}}, 3, 1, false, true},
+		{"validation error is not retried", []error{errors.New("ValidationException")}, 3, 1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testSettings()
+			settings.PutMaxAttempts = tt.maxAttempts
+			settings.PutRetryBase = time.Millisecond
+			db := &fakeDB{putErrs: tt.putErrs}
+
+			result, err := Process(context.Background(), db, settings, testMessage())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(db.puts) != tt.wantPuts {
+				t.Errorf("puts = %d, want %d", len(db.puts), tt.wantPuts)
+			}
+			if result.Duplicate != tt.wantDuplicate {
+				t.Errorf("duplicate = %v, want %v", result.Duplicate, tt.wantDuplicate)
+			}
+		})
+	}
+}
+
+func TestPutRetryStopsWhenContextEnds(t *testing.T) {
+	settings := testSettings()
+	settings.PutMaxAttempts = 3
+	settings.PutRetryBase = time.Hour
+	db := &fakeDB{putErrs: []error{&types.ProvisionedThroughputExceededException{}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := Process(ctx, db, settings, testMessage()); err == nil {
+		t.Fatal("Process succeeded after its context ended")
+	}
+	if len(db.puts) != 1 {
+		t.Errorf("puts = %d, want no retry once the context ended", len(db.puts))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt, full := range []time.Duration{base, 2 * base, 4 * base} {
+		for i := 0; i < 20; i++ {
+			if delay := backoff(base, attempt+1); delay < full/2 || delay > full {
+				t.Fatalf("backoff(attempt %d) = %s, want within [%s, %s]", attempt+1, delay, full/2, full)
+			}
+		}
+	}
+	if delay := backoff(0, 1); delay != 0 {
+		t.Errorf("backoff with no base = %s, want 0", delay)
+	}
+}
+
+func TestNewClientDisablesSDKRetries(t *testing.T) {
+	client := NewClient(aws.Config{Region: "us-east-1"})
+	if _, ok := client.Options().Retryer.(aws.NopRetryer); !ok {
+		t.Errorf("Retryer = %T, want aws.NopRetryer so PUT_MAX_ATTEMPTS is exact", client.Options().Retryer)
+	}
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/config"
+	"memory-machine/internal/models"
+)
+
+// DynamoDBAPI is the subset of the DynamoDB client Process uses: PutItem for
+// records, UpdateItem for rollup counters, and TransactWriteItems for
+// rollup-only records.
+type DynamoDBAPI interface {
+	PutItemAPI
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// rollupHourLayout keys rollup items by UTC hour.
+const rollupHourLayout = "2006-01-02T15"
+
+// rollupUpdate adds one record, its redaction count, and its text size to the
+// tenant's counters for the hour it was processed.
+func rollupUpdate(settings config.Settings, message models.InternalMessage, processedTime time.Time, redactions int) *types.Update {
+	return &types.Update{
+		TableName: stringPtr(settings.RollupTableName),
+		Key: map[string]types.AttributeValue{
+			"tenant_id": &types.AttributeValueMemberS{Value: message.TenantID},
+			"hour":      &types.AttributeValueMemberS{Value: processedTime.UTC().Format(rollupHourLayout)},
+		},
+		UpdateExpression: stringPtr("ADD records :one, redactions :redactions, text_bytes :bytes"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":        &types.AttributeValueMemberN{Value: "1"},
+			":redactions": &types.AttributeValueMemberN{Value: strconv.Itoa(redactions)},
+			":bytes":      &types.AttributeValueMemberN{Value: strconv.Itoa(len(message.Text))},
+		},
+	}
+}
+
+// incrementRollup atomically applies rollupUpdate for a record that was
+// persisted individually.
+func incrementRollup(ctx context.Context, db DynamoDBAPI, settings config.Settings, message models.InternalMessage, processedTime time.Time, redactions int) error {
+	update := rollupUpdate(settings, message, processedTime, redactions)
+	_, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 update.TableName,
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if err != nil {
+		return fmt.Errorf("rollup update error: %w", err)
+	}
+	return nil
+}
+
+// rollupOnce counts a rollup-only record at most once. Its marker item (the
+// tenant_id and log_id with no text) is inserted under the usual condition in
+// the same transaction as the rollup update, so a redelivery cancels the
+// transaction instead of adding again. It reports whether the record had
+// already been counted.
+func rollupOnce(ctx context.Context, db DynamoDBAPI, settings config.Settings, message models.InternalMessage, marker map[string]types.AttributeValue, processedTime time.Time, redactions int) (bool, error) {
+	_, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           stringPtr(settings.DynamoDBTableName),
+				Item:                marker,
+				ConditionExpression: stringPtr("attribute_not_exists(tenant_id) AND attribute_not_exists(log_id)"),
+			}},
+			{Update: rollupUpdate(settings, message, processedTime, redactions)},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("rollup transaction error: %w", err)
+	}
+	return false, nil
+}
+
+// rollupMarker is the item a rollup-only record leaves in the tenant logs
+// table: enough to detect a redelivery, without the text.
+func rollupMarker(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	marker := map[string]types.AttributeValue{
+		"rolled_up": &types.AttributeValueMemberBOOL{Value: true},
+	}
+	for _, name := range []string{"tenant_id", "log_id", "processed_at", "schema_version", "expires_at"} {
+		if value, ok := item[name]; ok {
+			marker[name] = value
+		}
+	}
+	return marker
+}
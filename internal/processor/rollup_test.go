@@ -0,0 +1,199 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"memory-machine/internal/models"
+)
+
+// rollupTotals sums the counters each update adds, keyed by tenant and hour
+// and then by counter name.
+func rollupTotals(t *testing.T, updates []*types.Update) map[string]map[string]int {
+	t.Helper()
+	totals := map[string]map[string]int{}
+	for _, update := range updates {
+		if got := *update.UpdateExpression; got != "ADD records :one, redactions :redactions, text_bytes :bytes" {
+			t.Fatalf("UpdateExpression = %q, want an atomic ADD", got)
+		}
+		key := stringAttr(update.Key, "tenant_id") + "|" + stringAttr(update.Key, "hour")
+		if totals[key] == nil {
+			totals[key] = map[string]int{}
+		}
+		for counter, value := range map[string]string{"records": ":one", "redactions": ":redactions", "text_bytes": ":bytes"} {
+			n, err := strconv.Atoi(numberAttr(update.ExpressionAttributeValues, value))
+			if err != nil {
+				t.Fatalf("%s: %v", value, err)
+			}
+			totals[key][counter] += n
+		}
+	}
+	return totals
+}
+
+// updateItems returns the rollup updates sent with UpdateItem.
+func updateItems(db *fakeDB) []*types.Update {
+	var updates []*types.Update
+	for _, input := range db.updates {
+		updates = append(updates, &types.Update{
+			TableName:                 input.TableName,
+			Key:                       input.Key,
+			UpdateExpression:          input.UpdateExpression,
+			ExpressionAttributeValues: input.ExpressionAttributeValues,
+		})
+	}
+	return updates
+}
+
+// committedUpdates returns the rollup updates of committed transactions.
+func committedUpdates(db *fakeDB) []*types.Update {
+	var updates []*types.Update
+	for _, input := range db.committed {
+		updates = append(updates, input.TransactItems[1].Update)
+	}
+	return updates
+}
+
+// rollupKey is the key rollupTotals uses for acme at the hour of result.
+func rollupKey(t *testing.T, result Result) string {
+	t.Helper()
+	processed, err := time.Parse(time.RFC3339, result.ProcessedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "acme|" + processed.UTC().Format(rollupHourLayout)
+}
+
+func rollupMessages() []models.InternalMessage {
+	return []models.InternalMessage{
+		models.NewInternalMessage("acme", "log-1", "json_upload", "call 555-123-4567"),
+		models.NewInternalMessage("acme", "log-2", "json_upload", "no secrets here"),
+		models.NewInternalMessage("acme", "log-3", "json_upload", "mail jane@example.com or 555-987-6543"),
+	}
+}
+
+func TestRollupCountsPersistedRecords(t *testing.T) {
+	settings := testSettings()
+	settings.RollupTableName = "rollups"
+	// The redelivered log-1 fails its conditional write.
+	db := &fakeDB{putErrs: []error{nil, nil, nil, &types.ConditionalCheckFailedException{}}}
+
+	var last Result
+	messages := append(rollupMessages(), rollupMessages()[0])
+	for _, message := range messages {
+		result, err := Process(context.Background(), db, settings, message)
+		if err != nil {
+			t.Fatalf("Process %s: %v", message.LogID, err)
+		}
+		last = result
+	}
+	if !last.Duplicate {
+		t.Fatal("redelivery was not detected as a duplicate")
+	}
+	if len(db.updates) != 3 {
+		t.Fatalf("rollup updates = %d, want one per persisted record", len(db.updates))
+	}
+	if got := *db.updates[0].TableName; got != "rollups" {
+		t.Errorf("TableName = %q, want rollups", got)
+	}
+
+	totals := rollupTotals(t, updateItems(db))
+	got := totals[rollupKey(t, last)]
+	want := map[string]int{"records": 3, "redactions": 3, "text_bytes": len("call 555-123-4567") + len("no secrets here") + len("mail jane@example.com or 555-987-6543")}
+	for counter, value := range want {
+		if got[counter] != value {
+			t.Errorf("%s = %d, want %d (totals %v)", counter, got[counter], value, totals)
+		}
+	}
+}
+
+func TestRollupSkipsReprocess(t *testing.T) {
+	settings := testSettings()
+	settings.RollupTableName = "rollups"
+	message := testMessage()
+	message.Reprocess = true
+
+	_, db := processOne(t, settings, message)
+	if len(db.puts) != 1 || len(db.updates) != 0 {
+		t.Errorf("puts = %d, updates = %d, want the reprocess stored but not counted again", len(db.puts), len(db.updates))
+	}
+}
+
+func TestRollupUpdateFailureIsNotFatal(t *testing.T) {
+	settings := testSettings()
+	settings.RollupTableName = "rollups"
+	db := &fakeDB{updateErr: errors.New("throttled")}
+
+	if _, err := Process(context.Background(), db, settings, testMessage()); err != nil {
+		t.Fatalf("Process: %v, want a failed rollup update to be best-effort", err)
+	}
+	if len(db.puts) != 1 {
+		t.Errorf("puts = %d, want the record persisted", len(db.puts))
+	}
+}
+
+func TestRollupOnlyTenant(t *testing.T) {
+	settings := testSettings()
+	settings.RollupTableName = "rollups"
+	settings.RollupOnlyTenants = map[string]bool{"acme": true}
+	settings.RecordTTLDays = 7
+	db := &fakeDB{}
+
+	var results []Result
+	messages := append(rollupMessages(), rollupMessages()[0])
+	for _, message := range messages {
+		result, err := Process(context.Background(), db, settings, message)
+		if err != nil {
+			t.Fatalf("Process %s: %v", message.LogID, err)
+		}
+		results = append(results, result)
+	}
+	if len(db.puts) != 0 || len(db.updates) != 0 {
+		t.Fatalf("puts = %d, updates = %d, want only transactions", len(db.puts), len(db.updates))
+	}
+	for i, result := range results[:3] {
+		if !result.RolledUp || result.Duplicate {
+			t.Errorf("record %d = %+v, want rolled up", i, result)
+		}
+	}
+	if last := results[3]; last.RolledUp || !last.Duplicate {
+		t.Errorf("redelivery = %+v, want a duplicate that is not counted", last)
+	}
+
+	totals := rollupTotals(t, committedUpdates(db))
+	got := totals[rollupKey(t, results[0])]
+	if got["records"] != 3 || got["redactions"] != 3 {
+		t.Errorf("totals = %v, want 3 records with 3 redactions", totals)
+	}
+
+	put := db.committed[0].TransactItems[0].Put
+	if *put.TableName != "tenant-logs" || *put.ConditionExpression != "attribute_not_exists(tenant_id) AND attribute_not_exists(log_id)" {
+		t.Errorf("marker put = %s with %s, want a conditional insert into tenant-logs", *put.TableName, *put.ConditionExpression)
+	}
+	for _, name := range []string{"tenant_id", "log_id", "processed_at", "schema_version", "expires_at", "rolled_up"} {
+		if _, ok := put.Item[name]; !ok {
+			t.Errorf("marker has no %s", name)
+		}
+	}
+	for _, name := range []string{"original_text", "modified_data"} {
+		if _, ok := put.Item[name]; ok {
+			t.Errorf("marker stores %s", name)
+		}
+	}
+}
+
+func TestRollupOnlyTransactionError(t *testing.T) {
+	settings := testSettings()
+	settings.RollupTableName = "rollups"
+	settings.RollupOnlyTenants = map[string]bool{"acme": true}
+	db := &fakeDB{transactErr: errors.New("throttled")}
+
+	if _, err := Process(context.Background(), db, settings, testMessage()); err == nil {
+		t.Fatal("Process succeeded, want the transaction error so the record is retried")
+	}
+}
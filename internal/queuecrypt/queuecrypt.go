@@ -0,0 +1,98 @@
+package queuecrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// AttributeName is the SQS message attribute that marks an encrypted body.
+const AttributeName = "body_encryption"
+
+// AttributeValue identifies the KMS envelope scheme implemented by this package.
+const AttributeValue = "kms-envelope"
+
+// KMSAPI is the subset of the KMS client used for envelope encryption.
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// Envelope is the queue body sent in place of the plaintext message.
+type Envelope struct {
+	EncryptedKey []byte `json:"encrypted_key"`
+	Nonce        []byte `json:"nonce"`
+	Ciphertext   []byte `json:"ciphertext"`
+}
+
+// Encrypt seals plaintext with a fresh KMS data key and returns the JSON envelope.
+// A data key is used because KMS Encrypt only accepts 4KB of plaintext.
+func Encrypt(ctx context.Context, client KMSAPI, keyID string, plaintext []byte) ([]byte, error) {
+	dataKey, err := client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   &keyID,
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	return json.Marshal(Envelope{
+		EncryptedKey: dataKey.CiphertextBlob,
+		Nonce:        nonce,
+		Ciphertext:   gcm.Seal(nil, nonce, plaintext, nil),
+	})
+}
+
+// Decrypt opens a JSON envelope produced by Encrypt. No key ID is needed: KMS
+// resolves the key from the encrypted data key, so per-tenant keys decrypt as-is.
+func Decrypt(ctx context.Context, client KMSAPI, body []byte) ([]byte, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %w", err)
+	}
+
+	dataKey, err := client.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: envelope.EncryptedKey})
+	if err != nil {
+		return nil, fmt.Errorf("decrypt data key: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid envelope nonce length %d", len(envelope.Nonce))
+	}
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open envelope: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("init cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("init gcm: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,142 @@
+package queuecrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeKMS issues one fixed data key per key ID. The "encrypted" data key is
+// the key ID itself, so Decrypt can find the plaintext key again.
+type fakeKMS struct {
+	keys      map[string][]byte
+	generated []string
+}
+
+func newFakeKMS() *fakeKMS {
+	return &fakeKMS{keys: map[string][]byte{}}
+}
+
+func (f *fakeKMS) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	keyID := *params.KeyId
+	f.generated = append(f.generated, keyID)
+	if _, ok := f.keys[keyID]; !ok {
+		f.keys[keyID] = bytes.Repeat([]byte{byte(len(f.keys) + 1)}, 32)
+	}
+	return &kms.GenerateDataKeyOutput{Plaintext: f.keys[keyID], CiphertextBlob: []byte(keyID)}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	key, ok := f.keys[string(params.CiphertextBlob)]
+	if !ok {
+		return nil, errors.New("unknown data key")
+	}
+	return &kms.DecryptOutput{Plaintext: key}, nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	client := newFakeKMS()
+	plaintext := []byte(`{"tenant_id":"acme","text":"call 555-123-4567"}`)
+
+	sealed, err := Encrypt(context.Background(), client, "alias/ingest", plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("555-123-4567")) {
+		t.Fatalf("envelope contains plaintext: %s", sealed)
+	}
+	if len(client.generated) != 1 || client.generated[0] != "alias/ingest" {
+		t.Errorf("data keys generated for %v, want [alias/ingest]", client.generated)
+	}
+
+	opened, err := Decrypt(context.Background(), client, sealed)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Decrypt = %s, want %s", opened, plaintext)
+	}
+}
+
+func TestEncryptUsesFreshNonce(t *testing.T) {
+	client := newFakeKMS()
+	first, _ := Encrypt(context.Background(), client, "alias/ingest", []byte("same"))
+	second, _ := Encrypt(context.Background(), client, "alias/ingest", []byte("same"))
+	if bytes.Equal(first, second) {
+		t.Error("two encryptions of the same plaintext are identical")
+	}
+}
+
+func TestDecryptRejectsTamperedEnvelope(t *testing.T) {
+	client := newFakeKMS()
+	sealed, err := Encrypt(context.Background(), client, "alias/ingest", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(sealed, &envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Envelope)
+	}{
+		{"ciphertext", func(e *Envelope) { e.Ciphertext[0] ^= 0xff }},
+		{"nonce length", func(e *Envelope) { e.Nonce = e.Nonce[:4] }},
+		{"unknown data key", func(e *Envelope) { e.EncryptedKey = []byte("alias/other") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := Envelope{
+				EncryptedKey: append([]byte(nil), envelope.EncryptedKey...),
+				Nonce:        append([]byte(nil), envelope.Nonce...),
+				Ciphertext:   append([]byte(nil), envelope.Ciphertext...),
+			}
+			tt.mutate(&tampered)
+			body, _ := json.Marshal(tampered)
+			if _, err := Decrypt(context.Background(), client, body); err == nil {
+				t.Error("Decrypt accepted a tampered envelope")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsPlaintextBody(t *testing.T) {
+	if _, err := Decrypt(context.Background(), newFakeKMS(), []byte("not an envelope")); err == nil {
+		t.Error("Decrypt accepted a body that is not an envelope")
+	}
+}
+
+func TestRoundTripPerTenantKeys(t *testing.T) {
+	client := newFakeKMS()
+	acme, err := Encrypt(context.Background(), client, "alias/acme", []byte("acme text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := Encrypt(context.Background(), client, "alias/globex", []byte("globex text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(client.keys["alias/acme"], client.keys["alias/globex"]) {
+		t.Fatal("tenants share a data key")
+	}
+
+	// Decrypt needs no key ID: each envelope names its own key.
+	for _, tt := range []struct {
+		sealed []byte
+		want   string
+	}{{acme, "acme text"}, {globex, "globex text"}} {
+		opened, err := Decrypt(context.Background(), client, tt.sealed)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if string(opened) != tt.want {
+			t.Errorf("Decrypt = %q, want %q", opened, tt.want)
+		}
+	}
+}
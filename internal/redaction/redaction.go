@@ -0,0 +1,200 @@
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Placeholder replaces redacted matches unless a rule sets Replace.
+const Placeholder = "[REDACTED]"
+
+// Rule is a named pattern whose matches are redacted. When Group is non-zero
+// only that capture group is redacted, leaving the surrounding context intact.
+// Replace, when set, computes the replacement for each value; otherwise the
+// value becomes Placeholder.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Group   int
+	Replace func(value string) string
+}
+
+// Replacement strategies accepted by Strategy.
+const (
+	StrategyToken      = "token"
+	StrategyMask       = "mask"
+	StrategyKeepDomain = "keep-domain"
+	StrategyHash       = "hash"
+)
+
+// Strategy returns the replacement function for a named strategy. The hash
+// strategy needs key, for the same reason HashValue does.
+func Strategy(name string, key []byte) (func(string) string, error) {
+	switch name {
+	case StrategyToken:
+		return nil, nil
+	case StrategyMask:
+		return mask, nil
+	case StrategyKeepDomain:
+		return keepDomain, nil
+	case StrategyHash:
+		if len(key) == 0 {
+			return nil, errors.New("hash strategy requires a key")
+		}
+		return func(value string) string { return "[" + HashValue(key, value)[:16] + "]" }, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+}
+
+// mask replaces every character with an asterisk, preserving length.
+func mask(value string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(value))
+}
+
+// keepDomain masks the local part of an email address and keeps its domain.
+// Values without an @ are masked entirely.
+func keepDomain(value string) string {
+	at := strings.LastIndexByte(value, '@')
+	if at < 0 {
+		return mask(value)
+	}
+	return mask(value[:at]) + value[at:]
+}
+
+func (r Rule) replacement(value string) string {
+	if r.Replace == nil {
+		return Placeholder
+	}
+	return r.Replace(value)
+}
+
+// DefaultRules are applied when no custom rules are configured.
+var DefaultRules = []Rule{
+	// Emails run first so digits inside an address are not half-redacted as a phone.
+	{Name: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{Name: "phone", Pattern: regexp.MustCompile(`\b\d{3}-\d{4}\b`)},
+}
+
+const (
+	usDate  = `(?:0[1-9]|1[0-2])/(?:0[1-9]|[12]\d|3[01])/(?:19|20)\d{2}`
+	isoDate = `(?:19|20)\d{2}-(?:0[1-9]|1[0-2])-(?:0[1-9]|[12]\d|3[01])`
+	dobCue  = `(?i:\b(?:dob|d\.o\.b\.?|date of birth|birth ?date|born(?: on)?))\s*[:\-]?\s*`
+)
+
+// DOBRules redact MM/DD/YYYY and YYYY-MM-DD dates. With requireContext only
+// dates introduced by a cue such as "DOB:" or "born on" are redacted, so
+// unrelated dates like invoice or shipping dates survive.
+func DOBRules(requireContext bool) []Rule {
+	dates := `(` + usDate + `|` + isoDate + `)`
+	if requireContext {
+		return []Rule{{Name: "dob", Pattern: regexp.MustCompile(dobCue + dates + `\b`), Group: 1}}
+	}
+	return []Rule{{Name: "dob", Pattern: regexp.MustCompile(`\b` + dates + `\b`), Group: 1}}
+}
+
+// DefaultSecretParams are the query parameter names URLSecretRules masks when
+// no list is given.
+var DefaultSecretParams = []string{
+	"token", "access_token", "refresh_token", "id_token", "auth", "code",
+	"apikey", "api_key", "key", "secret", "client_secret",
+	"password", "passwd", "pwd", "sig", "signature", "session", "sessionid",
+	"x-amz-signature", "x-amz-credential", "x-amz-security-token",
+}
+
+// URLSecretRules redact the values of sensitive query parameters, such as
+// ?token=... or &apikey=..., keeping the names so URLs stay readable. Names
+// match case-insensitively and only right after ? or &, so "monkey=" is left
+// alone; params replaces DefaultSecretParams when non-empty. Values exclude
+// [, ] and *, so the rule never matches its own [REDACTED], hash, or mask
+// output and Leaks stays quiet on redacted text.
+func URLSecretRules(params []string) []Rule {
+	if len(params) == 0 {
+		params = DefaultSecretParams
+	}
+	quoted := make([]string, len(params))
+	for i, param := range params {
+		quoted[i] = regexp.QuoteMeta(param)
+	}
+	pattern := `(?i:[?&](?:` + strings.Join(quoted, "|") + `)=)([^&#\s"'<>\[\]*]+)`
+	return []Rule{{Name: "url_secret", Pattern: regexp.MustCompile(pattern), Group: 1}}
+}
+
+// RuleMatches lists the values a single rule redacted. Spans holds the
+// [start, end) byte offset of each value in the text the rule was applied to,
+// which already reflects replacements made by earlier rules.
+type RuleMatches struct {
+	Rule   string
+	Values []string
+	Spans  [][2]int
+}
+
+// Result is the outcome of applying a rule set to a text.
+type Result struct {
+	Text string
+	// Matches holds one entry per rule that matched, in rule order.
+	Matches []RuleMatches
+}
+
+// Apply runs each rule in turn over text, so later rules see earlier replacements.
+func Apply(text string, rules []Rule) Result {
+	result := Result{Text: text}
+	for _, rule := range rules {
+		indexes := rule.Pattern.FindAllStringSubmatchIndex(result.Text, -1)
+		if len(indexes) == 0 {
+			continue
+		}
+		match := RuleMatches{Rule: rule.Name}
+		var redacted strings.Builder
+		last := 0
+		for _, index := range indexes {
+			start, end := index[2*rule.Group], index[2*rule.Group+1]
+			if start < 0 {
+				continue
+			}
+			match.Values = append(match.Values, result.Text[start:end])
+			match.Spans = append(match.Spans, [2]int{start, end})
+			redacted.WriteString(result.Text[last:start])
+			redacted.WriteString(rule.replacement(result.Text[start:end]))
+			last = end
+		}
+		if len(match.Values) == 0 {
+			continue
+		}
+		redacted.WriteString(result.Text[last:])
+		result.Text = redacted.String()
+		result.Matches = append(result.Matches, match)
+	}
+	return result
+}
+
+// Matching returns the names of rules that match text, in rule order.
+func Matching(text string, rules []Rule) []string {
+	var names []string
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(text) {
+			names = append(names, rule.Name)
+		}
+	}
+	return names
+}
+
+// Leaks returns the names of rules that still match text, which should be
+// empty for the output of Apply over the same rules.
+func Leaks(text string, rules []Rule) []string {
+	return Matching(text, rules)
+}
+
+// HashValue returns a keyed hash of a matched value. A key is required because
+// unkeyed hashes of short PII such as phone numbers are trivially brute-forced.
+func HashValue(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
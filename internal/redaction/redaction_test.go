@@ -0,0 +1,151 @@
+package redaction
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplySpans(t *testing.T) {
+	text := "mail a@b.io or c@d.io, call 555-1234 or 555-9876"
+	result := Apply(text, DefaultRules)
+
+	want := []RuleMatches{
+		{Rule: "email", Values: []string{"a@b.io", "c@d.io"}, Spans: [][2]int{{5, 11}, {15, 21}}},
+		// Phone offsets are into the text after the emails were replaced.
+		{Rule: "phone", Values: []string{"555-1234", "555-9876"}, Spans: [][2]int{{36, 44}, {48, 56}}},
+	}
+	if !reflect.DeepEqual(result.Matches, want) {
+		t.Fatalf("Matches = %+v, want %+v", result.Matches, want)
+	}
+	if got := "mail [REDACTED] or [REDACTED], call [REDACTED] or [REDACTED]"; result.Text != got {
+		t.Errorf("Text = %q, want %q", result.Text, got)
+	}
+}
+
+func TestDOBRules(t *testing.T) {
+	tests := []struct {
+		name           string
+		text           string
+		requireContext bool
+		want           string
+	}{
+		{"us format", "patient 03/14/1985 arrived", false, "patient [REDACTED] arrived"},
+		{"iso format", "patient 1985-03-14 arrived", false, "patient [REDACTED] arrived"},
+		{"both formats", "01/02/2000 and 2001-12-31", false, "[REDACTED] and [REDACTED]"},
+		{"invalid month kept", "ref 13/14/1985", false, "ref 13/14/1985"},
+		{"invalid day kept", "ref 1985-03-32", false, "ref 1985-03-32"},
+		{"part of a longer number kept", "id 1985-03-140", false, "id 1985-03-140"},
+		{"cue required: DOB", "DOB: 03/14/1985, seen 2024-01-05", true, "DOB: [REDACTED], seen 2024-01-05"},
+		{"cue required: born on", "born on 1985-03-14", true, "born on [REDACTED]"},
+		{"cue required: date of birth", "Date of birth - 03/14/1985", true, "Date of birth - [REDACTED]"},
+		{"cue required: no cue", "invoice dated 03/14/2024", true, "invoice dated 03/14/2024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Apply(tt.text, DOBRules(tt.requireContext)).Text; got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	text := "mail jane.doe@example.com or bob@mail.example.org"
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{StrategyToken, "mail [REDACTED] or [REDACTED]"},
+		{StrategyMask, "mail ******************** or ********************"},
+		{StrategyKeepDomain, "mail ********@example.com or ***@mail.example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			replace, err := Strategy(tt.strategy, nil)
+			if err != nil {
+				t.Fatalf("Strategy: %v", err)
+			}
+			rules := []Rule{{Name: "email", Pattern: DefaultRules[0].Pattern, Replace: replace}}
+			got := Apply(text, rules).Text
+			if got != tt.want {
+				t.Errorf("Apply = %q, want %q", got, tt.want)
+			}
+			if leaked := Leaks(got, rules); len(leaked) != 0 {
+				t.Errorf("output still matches %v", leaked)
+			}
+		})
+	}
+}
+
+func TestHashStrategy(t *testing.T) {
+	if _, err := Strategy(StrategyHash, nil); err == nil {
+		t.Error("hash strategy accepted without a key")
+	}
+	replace, err := Strategy(StrategyHash, []byte("key"))
+	if err != nil {
+		t.Fatalf("Strategy: %v", err)
+	}
+	first, second := replace("jane@example.com"), replace("jane@example.com")
+	if first != second || first == replace("bob@example.com") || len(first) != len("[]")+16 {
+		t.Errorf("hash replacements %q, %q; want a stable 16-character token per value", first, second)
+	}
+	if _, err := Strategy("shred", nil); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+}
+
+func TestURLSecretRules(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"token", "GET https://api.example.com/v1?token=abc123", "GET https://api.example.com/v1?token=[REDACTED]"},
+		{"apikey after benign param", "https://x.io/?page=2&apikey=s3cr3t&sort=asc", "https://x.io/?page=2&apikey=[REDACTED]&sort=asc"},
+		{"case-insensitive name", "https://x.io/?API_KEY=s3cr3t", "https://x.io/?API_KEY=[REDACTED]"},
+		{"presigned url", "https://b.s3.amazonaws.com/k?X-Amz-Credential=AKIA%2F20260101&X-Amz-Signature=deadbeef", "https://b.s3.amazonaws.com/k?X-Amz-Credential=[REDACTED]&X-Amz-Signature=[REDACTED]"},
+		{"stops at fragment", "https://x.io/cb?code=xyz#state", "https://x.io/cb?code=[REDACTED]#state"},
+		{"stops at quote", `href="https://x.io/?session=q1w2"`, `href="https://x.io/?session=[REDACTED]"`},
+		{"benign params kept", "https://x.io/search?q=shoes&page=3", "https://x.io/search?q=shoes&page=3"},
+		{"suffix of a name kept", "https://x.io/?monkey=banana&donkey=1", "https://x.io/?monkey=banana&donkey=1"},
+		{"not a query param", "the token=abc in prose", "the token=abc in prose"},
+		{"empty value kept", "https://x.io/?token=&page=1", "https://x.io/?token=&page=1"},
+	}
+	rules := URLSecretRules(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Apply(tt.text, rules).Text; got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLSecretRulesCustomParams(t *testing.T) {
+	rules := URLSecretRules([]string{"ticket"})
+	got := Apply("https://x.io/?ticket=t-1&token=abc", rules).Text
+	if want := "https://x.io/?ticket=[REDACTED]&token=abc"; got != want {
+		t.Errorf("Apply = %q, want %q", got, want)
+	}
+}
+
+func TestURLSecretRulesNoLeakOnOwnOutput(t *testing.T) {
+	text := "https://x.io/?token=abc123&page=2&api_key=s3cr3t"
+	for _, strategy := range []string{StrategyToken, StrategyMask, StrategyHash} {
+		t.Run(strategy, func(t *testing.T) {
+			replace, err := Strategy(strategy, []byte("key"))
+			if err != nil {
+				t.Fatalf("Strategy: %v", err)
+			}
+			rules := URLSecretRules(nil)
+			rules[0].Replace = replace
+			redacted := Apply(text, rules).Text
+			if redacted == text {
+				t.Fatalf("Apply left %q unchanged", text)
+			}
+			if leaked := Leaks(redacted, rules); len(leaked) != 0 {
+				t.Errorf("Leaks(%q) = %v, want none", redacted, leaked)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// provider is set once Init has installed an exporting tracer provider.
+var (
+	mu       sync.Mutex
+	provider *sdktrace.TracerProvider
+)
+
+// Init installs a global tracer provider exporting spans to endpoint over
+// OTLP/HTTP. It is a no-op when endpoint is empty, leaving the default no-op
+// provider in place, and when a provider is already installed; warm Lambda
+// containers call it on every invocation.
+func Init(ctx context.Context, endpoint, serviceName string) error {
+	if endpoint == "" {
+		return nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if provider != nil {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return fmt.Errorf("create otlp exporter: %w", err)
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Tracer returns a tracer from the global provider.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Flush exports any buffered spans. Lambda freezes the container between
+// invocations, so call it before each handler returns.
+func Flush(ctx context.Context) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if provider == nil {
+		return nil
+	}
+	return provider.ForceFlush(ctx)
+}
@@ -0,0 +1,26 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestInitWithoutEndpointIsNoop(t *testing.T) {
+	before := otel.GetTracerProvider()
+	if err := Init(context.Background(), "", "worker"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if otel.GetTracerProvider() != before {
+		t.Error("Init without an endpoint replaced the tracer provider")
+	}
+	if err := Flush(context.Background()); err != nil {
+		t.Errorf("Flush without a provider: %v", err)
+	}
+	_, span := Tracer("test").Start(context.Background(), "noop")
+	if span.SpanContext().IsValid() {
+		t.Error("no-op tracer produced a recording span")
+	}
+	span.End()
+}
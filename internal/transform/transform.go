@@ -0,0 +1,122 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm"
+
+	"memory-machine/internal/models"
+)
+
+// A running expression cannot be interrupted, so the timeout only bounds how
+// long Apply waits. These limits bound the work itself: without closures
+// (map, filter, reduce, ...) or let bindings every node is evaluated at most
+// once, so a run makes at most MaxNodes passes over strings no larger than
+// MaxNodes times MaxTextBytes, plus the VM's memory budget for builtins such as
+// repeat. That is milliseconds for typical expressions, but an adversarial one
+// near the limits can still outlive a short timeout by tens of milliseconds.
+const (
+	// MaxNodes is the largest expression, in syntax tree nodes, Compile accepts.
+	MaxNodes = 100
+	// MaxTextBytes is the longest text Apply evaluates an expression over.
+	MaxTextBytes = 64 * 1024
+)
+
+// env exposes the message fields an expression may read. Expressions have no
+// access to anything else, so they cannot perform I/O or mutate state.
+type env struct {
+	TenantID string `expr:"tenant_id"`
+	LogID    string `expr:"log_id"`
+	Source   string `expr:"source"`
+	Text     string `expr:"text"`
+}
+
+// Transform is a compiled expression that rewrites a message's text.
+type Transform struct {
+	program *vm.Program
+	timeout time.Duration
+}
+
+// Compile checks source against the message environment and the limits above.
+// The expression must evaluate to a string, which replaces the message text.
+func Compile(source string, timeout time.Duration) (*Transform, error) {
+	tree, err := parser.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	limits := &limitChecker{}
+	ast.Walk(&tree.Node, limits)
+	if limits.err != nil {
+		return nil, limits.err
+	}
+	if limits.nodes > MaxNodes {
+		return nil, fmt.Errorf("expression has %d nodes; the limit is %d", limits.nodes, MaxNodes)
+	}
+	program, err := expr.Compile(source, expr.Env(env{}), expr.AsKind(reflect.String))
+	if err != nil {
+		return nil, err
+	}
+	return &Transform{program: program, timeout: timeout}, nil
+}
+
+// Apply evaluates the transform for message, giving up after the configured
+// timeout. Texts over MaxTextBytes are rejected without running the expression.
+func (t *Transform) Apply(ctx context.Context, message models.InternalMessage) (string, error) {
+	if len(message.Text) > MaxTextBytes {
+		return "", fmt.Errorf("text is %d bytes; transforms run on at most %d", len(message.Text), MaxTextBytes)
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	type result struct {
+		text string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := expr.Run(t.program, env{
+			TenantID: message.TenantID,
+			LogID:    message.LogID,
+			Source:   message.Source,
+			Text:     message.Text,
+		})
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{text: out.(string)}
+	}()
+
+	select {
+	case r := <-done:
+		return r.text, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("transform timed out: %w", ctx.Err())
+	}
+}
+
+// limitChecker counts the nodes of a syntax tree and records the first node
+// that could evaluate more than once.
+type limitChecker struct {
+	nodes int
+	err   error
+}
+
+func (c *limitChecker) Visit(node *ast.Node) {
+	c.nodes++
+	if c.err != nil {
+		return
+	}
+	switch (*node).(type) {
+	case *ast.ClosureNode:
+		c.err = fmt.Errorf("closures (map, filter, reduce, ...) are not allowed in transforms")
+	case *ast.VariableDeclaratorNode:
+		c.err = fmt.Errorf("let bindings are not allowed in transforms")
+	}
+}
@@ -0,0 +1,91 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"memory-machine/internal/models"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		text   string
+		want   string
+	}{
+		{"text functions", "lower(trim(text))", "  Hello World ", "hello world"},
+		{"message fields", `tenant_id + ":" + source + ":" + text`, "hi", "acme:json_upload:hi"},
+		{"conditional", `len(text) > 3 ? text[:3] : text`, "truncate me", "tru"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := Compile(tt.source, time.Second)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.source, err)
+			}
+			got, err := transform.Apply(context.Background(), models.NewInternalMessage("acme", "log-1", "json_upload", tt.text))
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"syntax error", "lower(text"},
+		{"unknown field", "upper(password)"},
+		{"non-string result", "len(text)"},
+		{"closure", `join(map(1..1000000, text), "")`},
+		{"let binding", "let doubled = text + text; doubled + doubled"},
+		{"too many nodes", strings.Repeat("text + ", MaxNodes) + "text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.source, time.Second); err == nil {
+				t.Errorf("Compile(%q) succeeded, want an error", tt.source)
+			}
+		})
+	}
+}
+
+func TestApplyTimeout(t *testing.T) {
+	// Splitting and rejoining a long text takes tens of milliseconds.
+	transform, err := Compile(`join(split(upper(`+strings.Repeat("text + ", 20)+`text), ""), "-")`, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := models.NewInternalMessage("acme", "log-1", "json_upload", strings.Repeat("a", MaxTextBytes))
+
+	// Only the error is checked: a wall-clock bound would flake on loaded runners.
+	_, err = transform.Apply(context.Background(), message)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Apply error = %v, want a deadline error", err)
+	}
+}
+
+func TestApplyRejectsLargeText(t *testing.T) {
+	transform, err := Compile(`upper(text)`, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := models.NewInternalMessage("acme", "log-1", "json_upload", strings.Repeat("a", MaxTextBytes+1))
+	before := runtime.NumGoroutine()
+	if _, err := transform.Apply(context.Background(), message); err == nil {
+		t.Error("Apply accepted a text over MaxTextBytes")
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Apply started %d goroutines for a rejected text", after-before)
+	}
+}